@@ -0,0 +1,297 @@
+package attributes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	// TypeDate values are stored as YYYY-MM-DD strings
+	TypeDate = "date"
+)
+
+// Definition declares a custom user attribute and the rules its values must follow
+type Definition struct {
+	Type string `json:"type"`
+	// Required attributes must be set whenever a user's attributes are updated. Users are created without attributes,
+	// or with only the ones a seed fixture gives them, so an existing user may not have them until it is next updated.
+	Required  bool     `json:"required,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	MinLength *int     `json:"min_length,omitempty"`
+	MaxLength *int     `json:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Registry holds the custom attributes users can have, keyed by attribute name
+type Registry struct {
+	definitions map[string]Definition
+}
+
+// LoadRegistryFromEnv reads the attribute schema from the JSON file named by USER_ATTRIBUTES_SCHEMA.
+// Without it the registry is empty and users cannot have any custom attributes.
+func LoadRegistryFromEnv() (*Registry, error) {
+	path := os.Getenv("USER_ATTRIBUTES_SCHEMA")
+	if path == "" {
+		return NewRegistry(nil)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user attributes schema: %v", err)
+	}
+
+	var definitions map[string]Definition
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("failed to parse user attributes schema %s: %v", path, err)
+	}
+
+	registry, err := NewRegistry(definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Loaded user attributes schema", "path", path, "attributes", len(definitions))
+	return registry, nil
+}
+
+// NewRegistry checks the definitions are usable and compiles their patterns
+func NewRegistry(definitions map[string]Definition) (*Registry, error) {
+	registry := &Registry{definitions: make(map[string]Definition, len(definitions))}
+
+	for name, def := range definitions {
+		if !namePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid attribute name %q, names must be lowercase letters, digits and underscores", name)
+		}
+
+		switch def.Type {
+		case TypeString, TypeInteger, TypeNumber, TypeBoolean, TypeDate:
+		default:
+			return nil, fmt.Errorf("attribute %q has unknown type %q", name, def.Type)
+		}
+
+		if def.Pattern != "" {
+			pattern, err := regexp.Compile(def.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %q has an invalid pattern: %v", name, err)
+			}
+			def.pattern = pattern
+		}
+
+		registry.definitions[name] = def
+	}
+
+	return registry, nil
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Definitions returns the declared attributes, so clients can discover which custom fields users have
+func (r *Registry) Definitions() map[string]Definition {
+	return r.definitions
+}
+
+// Apply validates a patch of attribute values and merges it into the current attributes.
+// A null value removes the attribute. Attributes that are no longer declared are kept as they are,
+// but new values can only be written for declared attributes, and every required attribute must be set afterwards.
+func (r *Registry) Apply(current, patch map[string]any) (map[string]any, error) {
+	merged := make(map[string]any, len(current)+len(patch))
+	for name, value := range current {
+		merged[name] = value
+	}
+
+	for _, name := range sortedNames(patch) {
+		if patch[name] == nil {
+			if _, ok := r.definitions[name]; !ok {
+				return nil, fmt.Errorf("unknown attribute %q", name)
+			}
+			delete(merged, name)
+			continue
+		}
+
+		value, err := r.validate(name, patch[name])
+		if err != nil {
+			return nil, err
+		}
+		merged[name] = value
+	}
+
+	for name, def := range r.definitions {
+		if _, ok := merged[name]; def.Required && !ok {
+			return nil, fmt.Errorf("attribute %q is required", name)
+		}
+	}
+
+	return merged, nil
+}
+
+// ValidateValues checks every value against its attribute's definition without requiring any attributes to be present,
+// which is what filters matching on a subset of attributes and seed fixtures need
+func (r *Registry) ValidateValues(values map[string]any) (map[string]any, error) {
+	validated := make(map[string]any, len(values))
+	for _, name := range sortedNames(values) {
+		value, err := r.validate(name, values[name])
+		if err != nil {
+			return nil, err
+		}
+		validated[name] = value
+	}
+
+	return validated, nil
+}
+
+func (r *Registry) validate(name string, value any) (any, error) {
+	def, ok := r.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown attribute %q", name)
+	}
+
+	return def.validate(name, value)
+}
+
+func sortedNames(values map[string]any) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ParseFilterValue converts a query string value to the attribute's type, so it can be matched against stored values
+func (r *Registry) ParseFilterValue(name, raw string) (any, error) {
+	def, ok := r.definitions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown attribute %q", name)
+	}
+
+	var value any = raw
+	switch def.Type {
+	case TypeInteger:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q must be an integer", name)
+		}
+		value = n
+	case TypeNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q must be a number", name)
+		}
+		value = n
+	case TypeBoolean:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q must be true or false", name)
+		}
+		value = b
+	}
+
+	return def.validate(name, value)
+}
+
+func (d Definition) validate(name string, value any) (any, error) {
+	switch d.Type {
+	case TypeString, TypeDate:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("attribute %q must be a string", name)
+		}
+		if d.Type == TypeDate {
+			if _, err := time.Parse(time.DateOnly, s); err != nil {
+				return nil, fmt.Errorf("attribute %q must be a date formatted as YYYY-MM-DD", name)
+			}
+		}
+		return s, d.validateString(name, s)
+	case TypeInteger, TypeNumber:
+		n, ok := toFloat(value)
+		if !ok {
+			return nil, fmt.Errorf("attribute %q must be a %s", name, d.Type)
+		}
+		if d.Type == TypeInteger {
+			if n != math.Trunc(n) || math.Abs(n) > 1<<53 {
+				return nil, fmt.Errorf("attribute %q must be an integer", name)
+			}
+			if err := d.validateRange(name, n); err != nil {
+				return nil, err
+			}
+			return int64(n), nil
+		}
+		return n, d.validateRange(name, n)
+	case TypeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("attribute %q must be a boolean", name)
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("attribute %q has unknown type %q", name, d.Type)
+}
+
+func (d Definition) validateString(name, s string) error {
+	length := utf8.RuneCountInString(s)
+	if d.MinLength != nil && length < *d.MinLength {
+		return fmt.Errorf("attribute %q must be at least %d characters", name, *d.MinLength)
+	}
+	if d.MaxLength != nil && length > *d.MaxLength {
+		return fmt.Errorf("attribute %q must be at most %d characters", name, *d.MaxLength)
+	}
+	if d.pattern != nil && !d.pattern.MatchString(s) {
+		return fmt.Errorf("attribute %q must match %s", name, d.Pattern)
+	}
+
+	if len(d.Enum) > 0 {
+		for _, allowed := range d.Enum {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("attribute %q must be one of %v", name, d.Enum)
+	}
+
+	return nil
+}
+
+func (d Definition) validateRange(name string, n float64) error {
+	if d.Min != nil && n < *d.Min {
+		return fmt.Errorf("attribute %q must be at least %v", name, *d.Min)
+	}
+	if d.Max != nil && n > *d.Max {
+		return fmt.Errorf("attribute %q must be at most %v", name, *d.Max)
+	}
+
+	return nil
+}
+
+func toFloat(value any) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+
+	return 0, false
+}
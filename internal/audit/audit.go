@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+)
+
+// Metadata describes the request an audited change was made in
+type Metadata struct {
+	RequestID string
+	IPAddress string
+}
+
+type contextKey struct{}
+
+// WithMetadata attaches the request's metadata to the context, so audit events written while handling it record where they came from
+func WithMetadata(ctx context.Context, metadata Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, metadata)
+}
+
+// MetadataFromContext returns the metadata of the request being handled, which is empty for background work
+func MetadataFromContext(ctx context.Context) Metadata {
+	metadata, _ := ctx.Value(contextKey{}).(Metadata)
+	return metadata
+}
+
+// Diff returns the fields that differ between two versions of a record, as they appear in its JSON encoding,
+// with their values before and after the change
+func Diff(before, after any) (map[string]any, map[string]any, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changedBefore := map[string]any{}
+	changedAfter := map[string]any{}
+	for name, value := range beforeFields {
+		if afterValue, ok := afterFields[name]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changedBefore[name] = value
+		}
+	}
+	for name, value := range afterFields {
+		if beforeValue, ok := beforeFields[name]; !ok || !reflect.DeepEqual(value, beforeValue) {
+			changedAfter[name] = value
+		}
+	}
+
+	return changedBefore, changedAfter, nil
+}
+
+func fields(record any) (map[string]any, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,97 @@
+// Package database routes queries between the primary database and an optional read replica
+package database
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// replicaLagQuery returns how many seconds the replica is behind the primary. A replica that has replayed everything
+// it received is caught up even if the primary has been idle for a while.
+const replicaLagQuery = `
+	SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+// ReadRouter sends read only queries to a replica while it is reachable and no further behind the primary than the
+// maximum lag, and to the primary otherwise. Writes, and reads that must see the caller's own writes, always use Primary.
+type ReadRouter struct {
+	primary *pgxpool.Pool
+	replica *pgxpool.Pool
+	maxLag  time.Duration
+	healthy atomic.Bool
+}
+
+// NewReadRouter returns a router over the pools, replica can be nil to send every query to the primary.
+// The replica is only used once a health check has passed, see CheckReplica and MonitorReplica.
+func NewReadRouter(primary, replica *pgxpool.Pool, maxLag time.Duration) *ReadRouter {
+	return &ReadRouter{primary: primary, replica: replica, maxLag: maxLag}
+}
+
+// Primary returns the pool of the primary database
+func (r *ReadRouter) Primary() *pgxpool.Pool {
+	return r.primary
+}
+
+// Reader returns the pool read only queries should use
+func (r *ReadRouter) Reader() *pgxpool.Pool {
+	if r.replica != nil && r.healthy.Load() {
+		return r.replica
+	}
+	return r.primary
+}
+
+// CheckReplica measures the replica's lag and marks it healthy if it is reachable and within the maximum lag
+func (r *ReadRouter) CheckReplica(ctx context.Context) {
+	if r.replica == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var lagSeconds float64
+	err := r.replica.QueryRow(ctx, replicaLagQuery).Scan(&lagSeconds)
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	healthy := err == nil && lag <= r.maxLag
+
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			slog.Info("Read replica is healthy, routing reads to it", "lag", lag)
+		} else {
+			slog.Warn("Read replica is unavailable or lagging, routing reads to the primary", "error", err, "lag", lag, "max_lag", r.maxLag)
+		}
+	}
+}
+
+// MonitorReplica checks the replica every interval until the context is cancelled.
+// The returned channel is closed once monitoring has stopped.
+func (r *ReadRouter) MonitorReplica(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if r.replica == nil {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.CheckReplica(ctx)
+			}
+		}
+	}()
+
+	return done
+}
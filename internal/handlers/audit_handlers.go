@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+const (
+	defaultAuditEventLimit = 50
+	maxAuditEventLimit     = 500
+)
+
+type auditEventsResponse struct {
+	Events []models.AuditEvent `json:"events"`
+	// NextCursor is passed back as the cursor query parameter to fetch the next, older page, and is omitted on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// GetAuditEvents lists audit events newest first, filtered by the actor, action, target, request_id,
+// created_after and created_before query parameters and paginated with limit and cursor
+func GetAuditEvents(db *database.ReadRouter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, limit, err := parseAuditEventFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// One extra event is fetched to tell whether there is another page
+		events, err := queries.GetAuditEvents(r.Context(), db.Reader(), filter, limit+1)
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch audit events")
+			return
+		}
+
+		response := auditEventsResponse{Events: events}
+		if len(events) > limit {
+			response.Events = events[:limit]
+			response.NextCursor = strconv.FormatInt(response.Events[limit-1].ID, 10)
+		}
+		if response.Events == nil {
+			response.Events = []models.AuditEvent{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to encode audit events", "error", err)
+		}
+	})
+}
+
+func parseAuditEventFilter(r *http.Request) (queries.AuditEventFilter, int, error) {
+	query := r.URL.Query()
+	filter := queries.AuditEventFilter{
+		ActorEmail: strings.TrimSpace(query.Get("actor")),
+		Action:     strings.TrimSpace(query.Get("action")),
+		Target:     strings.TrimSpace(query.Get("target")),
+		RequestID:  strings.TrimSpace(query.Get("request_id")),
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return queries.AuditEventFilter{}, 0, fmt.Errorf("Invalid %s, expected an RFC 3339 timestamp", name)
+		}
+		*target = &t
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || id <= 0 {
+			return queries.AuditEventFilter{}, 0, fmt.Errorf("Invalid cursor")
+		}
+		filter.BeforeID = id
+	}
+
+	limit := defaultAuditEventLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditEventLimit {
+			return queries.AuditEventFilter{}, 0, fmt.Errorf("Invalid limit, expected a number between 1 and %d", maxAuditEventLimit)
+		}
+		limit = n
+	}
+
+	return filter, limit, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -9,95 +10,92 @@ import (
 	"time"
 
 	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
-	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
 	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func SignUp(dbPool *pgxpool.Pool) http.Handler {
+func SignUp(userRepo repository.UserRepository, sessionRepo repository.SessionRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := template.HTMLEscapeString(r.FormValue("email"))
-		firstName := template.HTMLEscapeString(r.FormValue("first_name"))
-		lastName := template.HTMLEscapeString(r.FormValue("last_name"))
-		password := template.HTMLEscapeString(r.FormValue("password"))
-
-		if email == "" || firstName == "" || lastName == "" || password == "" {
+		if r.FormValue("email") == "" {
 			slog.Error("Email, first name, last name or password is empty")
 			http.Error(w, "Email, first name, last name or password is empty", http.StatusBadRequest)
 			return
 		}
 
-		passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		email, err := validation.NormalizeEmail(r.FormValue("email"))
 		if err != nil {
-			slog.Error("Failed to hash password", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		passwordHashString := string(passwordHash)
 
-		err = queries.SignUpNewUser(r.Context(), dbPool, models.User{
-			Email:     email,
-			FirstName: &firstName,
-			LastName:  &lastName,
-			Password:  &passwordHashString,
-		})
-		if err != nil {
-			slog.Error("Failed to sign up new user", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		user, ok := newUserFromForm(w, r, email)
+		if !ok {
 			return
 		}
 
-		accessToken, err := middleware.CreateAccessToken(email)
+		userID, err := userRepo.SignUpNewUser(r.Context(), user)
 		if err != nil {
-			slog.Error("Failed to create JWT token", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeQueryError(w, err, "Failed to sign up new user")
 			return
 		}
 
-		refreshToken, err := middleware.CreateRefreshToken(email)
-		if err != nil {
-			slog.Error("Failed to create refresh token", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		startSession(w, r, sessionRepo, userID, email)
+	})
+}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    refreshToken,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-			Path:     "/",
-			Expires:  time.Now().Add(24 * 7 * time.Hour),
-		})
+// newUserFromForm reads the names and password of a new user from the sign up form and hashes the password.
+// The email is passed in because it comes either from the form or from the invitation being accepted.
+func newUserFromForm(w http.ResponseWriter, r *http.Request, email string) (models.User, bool) {
+	firstName := template.HTMLEscapeString(r.FormValue("first_name"))
+	lastName := template.HTMLEscapeString(r.FormValue("last_name"))
+	password := r.FormValue("password")
 
-		response := map[string]string{
-			"token": accessToken,
-		}
+	if firstName == "" || lastName == "" || password == "" {
+		slog.Error("Email, first name, last name or password is empty")
+		http.Error(w, "Email, first name, last name or password is empty", http.StatusBadRequest)
+		return models.User{}, false
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(response)
-		if err != nil {
-			slog.Error("Failed to encode response", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-	})
+	if err := validation.ValidatePassword(password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return models.User{}, false
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("Failed to hash password", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return models.User{}, false
+	}
+	passwordHashString := string(passwordHash)
+
+	return models.User{
+		Email:     email,
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Password:  &passwordHashString,
+	}, true
 }
 
-func Login(dbPool *pgxpool.Pool) http.Handler {
+func Login(userRepo repository.UserRepository, sessionRepo repository.SessionRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := template.HTMLEscapeString(r.FormValue("email"))
-		password := template.HTMLEscapeString(r.FormValue("password"))
+		password := r.FormValue("password")
 
-		if email == "" || password == "" {
+		if r.FormValue("email") == "" || password == "" {
 			slog.Error("Email or password is empty")
 			http.Error(w, "Email or password is empty", http.StatusBadRequest)
 			return
 		}
 
-		user, err := queries.GetUserByEmail(r.Context(), dbPool, email)
+		email, err := validation.NormalizeEmail(r.FormValue("email"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, err := userRepo.GetUserByEmail(r.Context(), email)
 		if err != nil {
 			slog.Error("Failed to find user when logging in", "error", err)
 			http.Error(w, "User not found", http.StatusNotFound)
@@ -110,54 +108,65 @@ func Login(dbPool *pgxpool.Pool) http.Handler {
 			return
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(*user.Password), []byte(password))
-		if err != nil {
-			slog.Error("Failed to compare password hashes", "error", err)
+		if !checkPassword(r.Context(), userRepo, user, password) {
+			slog.Error("Incorrect password when logging in", "user_id", user.ID)
 			http.Error(w, "Failed to find user", http.StatusNotFound)
 			return
 		}
 
-		accessToken, err := middleware.CreateAccessToken(user.Email)
-		if err != nil {
-			slog.Error("Failed to create JWT token", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		startSession(w, r, sessionRepo, user.ID, email)
 
-		refreshToken, err := middleware.CreateRefreshToken(email)
-		if err != nil {
-			slog.Error("Failed to create refresh token", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+		slog.InfoContext(r.Context(), fmt.Sprintf("User logged in: %s", email))
+	})
+}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "refresh_token",
-			Value:    refreshToken,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-			Path:     "/",
-			Expires:  time.Now().Add(24 * 7 * time.Hour),
-		})
+// startSession creates a login session for the user, sets its refresh token cookie and responds with an access token
+// for the organization the session starts in
+func startSession(w http.ResponseWriter, r *http.Request, sessionRepo repository.SessionRepository, userID int, email string) {
+	sessionID, organizationID, err := sessionRepo.CreateSession(r.Context(), userID, r.UserAgent(), middleware.ClientIP(r), middleware.RefreshTokenTTL)
+	if err != nil {
+		writeQueryError(w, err, "Failed to create session")
+		return
+	}
 
-		response := map[string]string{
-			"token": accessToken,
-		}
+	accessToken, err := middleware.CreateOrganizationAccessToken(email, organizationID)
+	if err != nil {
+		slog.Error("Failed to create JWT token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(response)
-		if err != nil {
-			slog.Error("Failed to encode response", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
+	refreshToken, err := middleware.CreateRefreshToken(email, sessionID)
+	if err != nil {
+		slog.Error("Failed to create refresh token", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
-		slog.InfoContext(r.Context(), fmt.Sprintf("User logged in: %s", email))
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+		Expires:  time.Now().Add(middleware.RefreshTokenTTL),
 	})
+
+	response := map[string]string{
+		"token": accessToken,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error("Failed to encode response", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 }
 
-func RefreshToken() http.Handler {
+func RefreshToken(userRepo repository.UserRepository, sessionRepo repository.SessionRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("refresh_token")
 		if err != nil {
@@ -167,16 +176,23 @@ func RefreshToken() http.Handler {
 		}
 
 		refreshToken := cookie.Value
-		email, err := middleware.VerifyToken(refreshToken)
+		user, claims, err := middleware.AuthenticateToken(r.Context(), userRepo, refreshToken)
 		if err != nil {
 			slog.Error("Error validating refresh token", "error", err)
 			http.Error(w, "Session ended. Login again.", http.StatusUnauthorized)
 			return
 		}
 
-		accessToken, err := middleware.CreateAccessToken(email)
+		organizationID, err := sessionRepo.TouchSession(r.Context(), claims.SessionID, user.ID)
 		if err != nil {
-			slog.Error("Failed to create new access token", "error", err, "email", email)
+			slog.Error("Refresh token session is no longer active", "error", err, "session_id", claims.SessionID)
+			http.Error(w, "Session ended. Login again.", http.StatusUnauthorized)
+			return
+		}
+
+		accessToken, err := middleware.CreateOrganizationAccessToken(user.Email, organizationID)
+		if err != nil {
+			slog.Error("Failed to create new access token", "error", err, "email", user.Email)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
@@ -194,3 +210,84 @@ func RefreshToken() http.Handler {
 		}
 	})
 }
+
+// ChangePassword replaces the caller's password after checking their current one. Every other session is revoked,
+// while the session the request was made from, identified by its refresh token cookie, stays logged in.
+func ChangePassword(userRepo repository.UserRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		currentPassword := r.FormValue("current_password")
+		newPassword := r.FormValue("new_password")
+
+		if currentPassword == "" || newPassword == "" {
+			http.Error(w, "Current password and new password are required", http.StatusBadRequest)
+			return
+		}
+
+		if !checkPassword(r.Context(), userRepo, user, currentPassword) {
+			slog.Warn("Incorrect current password when changing password", "user_id", user.ID)
+			http.Error(w, "Incorrect password", http.StatusForbidden)
+			return
+		}
+
+		if err := validation.ValidatePassword(newPassword); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+		if err != nil {
+			slog.Error("Failed to hash password", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// Without a valid refresh token for this user there is no current session to keep, so every session is revoked
+		var currentSessionID int64
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			if claims, err := middleware.ParseToken(cookie.Value); err == nil && claims.Email == user.Email {
+				currentSessionID = claims.SessionID
+			}
+		}
+
+		err = userRepo.ChangePassword(r.Context(), user.ID, string(passwordHash), currentSessionID)
+		if err != nil {
+			writeQueryError(w, err, "Failed to change password", "user_id", user.ID)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		slog.Info("User changed password", "user_id", user.ID)
+	})
+}
+
+// checkPassword reports whether the password is the user's. Passwords used to be HTML escaped before they were hashed,
+// so a password that only matches its escaped form is rehashed as it was sent, after which it matches directly.
+func checkPassword(ctx context.Context, userRepo repository.UserRepository, user models.User, password string) bool {
+	if user.Password == nil {
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(*user.Password), []byte(password)) == nil {
+		return true
+	}
+
+	escaped := template.HTMLEscapeString(password)
+	if escaped == password || bcrypt.CompareHashAndPassword([]byte(*user.Password), []byte(escaped)) != nil {
+		return false
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err == nil {
+		err = userRepo.RehashPassword(ctx, user.ID, string(passwordHash))
+	}
+	if err != nil {
+		// The password is still correct, so the user gets in and the hash is upgraded on a later login
+		slog.Error("Failed to rehash HTML escaped password", "error", err, "user_id", user.ID)
+	}
+
+	return true
+}
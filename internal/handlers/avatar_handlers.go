@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/images"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/storage"
+	"github.com/anishsharma21/go-backend-starter-template/internal/tokens"
+)
+
+const (
+	maxAvatarBytes = 5 << 20
+	avatarSize     = 256
+)
+
+// UploadAvatar replaces the caller's avatar with the image in the "avatar" field of a multipart upload.
+// The image is validated, cropped to a square and re-encoded as a PNG thumbnail before it is stored.
+func UploadAvatar(userRepo repository.UserRepository, blobs storage.BlobStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		// Leave some room for the multipart boundaries and headers around the image
+		r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+
+		file, _, err := r.FormFile("avatar")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, fmt.Sprintf("Avatar must be smaller than %d bytes", maxAvatarBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			slog.Error("Failed to read avatar upload", "error", err)
+			http.Error(w, "A multipart form with an avatar file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxAvatarBytes+1))
+		if err != nil {
+			slog.Error("Failed to read avatar file", "error", err)
+			http.Error(w, "Failed to read avatar", http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxAvatarBytes {
+			http.Error(w, fmt.Sprintf("Avatar must be smaller than %d bytes", maxAvatarBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		thumbnail, err := images.SquareThumbnail(data, avatarSize)
+		if errors.Is(err, images.ErrUnsupportedImage) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		// A new key for every upload means clients and caches never see a stale avatar
+		name, _, err := tokens.Generate()
+		if err != nil {
+			slog.Error("Failed to generate avatar key", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		key := fmt.Sprintf("avatars/%d/%s.png", user.ID, name[:32])
+
+		err = blobs.Put(r.Context(), key, bytes.NewReader(thumbnail), "image/png")
+		if err != nil {
+			slog.Error("Failed to store avatar", "error", err, "key", key)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		avatarURL := blobs.URL(key)
+		previousKey, err := userRepo.SetUserAvatar(r.Context(), user.ID, key, avatarURL)
+		if err != nil {
+			slog.Error("Failed to set user avatar", "error", err, "user_id", user.ID)
+			if err := blobs.Delete(r.Context(), key); err != nil {
+				slog.Error("Failed to delete orphaned avatar", "error", err, "key", key)
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if previousKey != nil {
+			if err := blobs.Delete(r.Context(), *previousKey); err != nil {
+				slog.Error("Failed to delete previous avatar", "error", err, "key", *previousKey)
+			}
+		}
+
+		response := map[string]string{
+			"avatar_url": avatarURL,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to encode avatar response", "error", err)
+		}
+
+		slog.Info("User avatar updated", "user_id", user.ID, "key", key)
+	})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/tokens"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/selectors"
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
+)
+
+// emailChangeTTL is how long the confirmation sent to a new email address stays valid
+const emailChangeTTL = 24 * time.Hour
+
+// ChangeEmail starts changing the caller's email by queueing an email with a confirmation token to the new address.
+// The email is only changed once the token is confirmed with ConfirmEmailChange.
+func ChangeEmail(emailRepo repository.EmailChangeRepository, userRepo repository.UserRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		password := r.FormValue("password")
+
+		if r.FormValue("new_email") == "" || password == "" {
+			http.Error(w, "New email and password are required", http.StatusBadRequest)
+			return
+		}
+
+		newEmail, err := validation.NormalizeEmail(r.FormValue("new_email"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if newEmail == user.Email {
+			http.Error(w, "New email must be different to the current email", http.StatusBadRequest)
+			return
+		}
+
+		if !checkPassword(r.Context(), userRepo, user, password) {
+			slog.Warn("Incorrect password when changing email", "user_id", user.ID)
+			http.Error(w, "Incorrect password", http.StatusForbidden)
+			return
+		}
+
+		exists, err := emailRepo.EmailExists(r.Context(), newEmail)
+		if err != nil {
+			writeQueryError(w, err, "Failed to check whether email exists")
+			return
+		}
+		if exists {
+			http.Error(w, "Email is already in use", http.StatusConflict)
+			return
+		}
+
+		err = emailRepo.CreateEmailChangeRequest(r.Context(), user.ID, newEmail, emailChangeTTL)
+		if err != nil {
+			writeQueryError(w, err, "Failed to create email change request", "user_id", user.ID)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		slog.Info("Email change requested", "user_id", user.ID)
+	})
+}
+
+// ConfirmEmailChangeView renders the page the link in the confirmation email opens. The token is only confirmed
+// once the user presses its button, which posts it to ConfirmEmailChange, so link previews and scanners that open
+// the link can't confirm the change.
+func ConfirmEmailChangeView(tmpl *template.Template) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimSpace(r.URL.Query().Get("token"))
+		if token == "" {
+			http.Error(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		err := tmpl.ExecuteTemplate(w, selectors.ConfirmEmailPage.BaseHTML, map[string]string{"Token": token})
+		if err != nil {
+			slog.Error("Failed to execute template", "error", err, "template", selectors.ConfirmEmailPage.BaseHTML)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// ConfirmEmailChange swaps the user's email once they prove they own the new address, then notifies the old address.
+// Every token issued for the old email stops working, so the user has to log in again with their new email.
+func ConfirmEmailChange(emailRepo repository.EmailChangeRepository, jobRepo repository.JobRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimSpace(r.FormValue("token"))
+		if token == "" {
+			http.Error(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		oldEmail, request, err := emailRepo.ConfirmEmailChange(r.Context(), tokens.Hash(token))
+		if errors.Is(err, queries.ErrEmailChangeNotFound) {
+			http.Error(w, "Invalid or expired token", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, queries.ErrEmailTaken) {
+			http.Error(w, "Email is already in use", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to confirm email change")
+			return
+		}
+
+		_, err = jobRepo.EnqueueJob(r.Context(), models.SendEmailJob{
+			To:      oldEmail,
+			Subject: "Your email address was changed",
+			Body: fmt.Sprintf(
+				"The email address for your account was changed to %s. If you did not make this change, contact support immediately.",
+				request.NewEmail,
+			),
+		}, queries.JobOptions{})
+		if err != nil {
+			slog.Error("Failed to queue notification of email change to old address", "error", err, "user_id", request.UserID)
+		}
+
+		// The confirmation page swaps the response into itself
+		if r.Header.Get("HX-Request") == "true" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, "Your email address is now %s, log in again with it.", template.HTMLEscapeString(request.NewEmail))
+			return
+		}
+
+		response := map[string]string{
+			"email": request.NewEmail,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to encode email change response", "error", err)
+		}
+	})
+}
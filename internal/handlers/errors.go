@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+)
+
+// writeQueryError responds to an error returned by the queries package. Missing rows are answered with 404 Not Found,
+// clashes with existing data with 409 Conflict and values the database rejected with 422 Unprocessable Entity.
+// Anything else is logged with the message and arguments and answered with 500 Internal Server Error.
+// Constraint violations are recognised whether or not the query ran in WithTx, which already maps them.
+func writeQueryError(w http.ResponseWriter, err error, logMessage string, logArgs ...any) {
+	err = queries.MapConstraintError(err)
+
+	var constraintErr *queries.ErrConstraint
+	switch {
+	case errors.As(err, &constraintErr) && errors.Is(err, queries.ErrConflict):
+		if constraintErr.Field == "" {
+			http.Error(w, "A record with the same values already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, fmt.Sprintf("A record with this %s already exists", constraintErr.Field), http.StatusConflict)
+	case errors.As(err, &constraintErr):
+		if constraintErr.Field == "" {
+			http.Error(w, "A value is invalid or too long", http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid value for %s", constraintErr.Field), http.StatusUnprocessableEntity)
+	case errors.Is(err, queries.ErrConflict):
+		http.Error(w, sentence(err), http.StatusConflict)
+	case errors.Is(err, queries.ErrNotFound):
+		http.Error(w, sentence(err), http.StatusNotFound)
+	case errors.Is(err, queries.ErrForbidden):
+		http.Error(w, sentence(err), http.StatusForbidden)
+	default:
+		slog.Error(logMessage, append([]any{"error", err}, logArgs...)...)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// sentence returns the message of the innermost error, such as "user not found", capitalized for a response body
+func sentence(err error) string {
+	for {
+		inner := errors.Unwrap(err)
+		if inner == nil || inner == queries.ErrNotFound || inner == queries.ErrConflict || inner == queries.ErrForbidden {
+			break
+		}
+		err = inner
+	}
+
+	message := strings.TrimSpace(err.Error())
+	r, size := utf8.DecodeRuneInString(message)
+	return string(unicode.ToUpper(r)) + message[size:]
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWriteQueryError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{
+			name:   "duplicate email",
+			err:    &pgconn.PgError{Code: "23505", ConstraintName: "email_unique", TableName: "users"},
+			status: http.StatusConflict,
+			body:   "A record with this email already exists",
+		},
+		{
+			name:   "wrapped duplicate slug",
+			err:    fmt.Errorf("failed to create organization: %w", &pgconn.PgError{Code: "23505", ConstraintName: "organizations_slug_unique", TableName: "organizations"}),
+			status: http.StatusConflict,
+			body:   "A record with this slug already exists",
+		},
+		{
+			name:   "duplicate without a known field",
+			err:    &pgconn.PgError{Code: "23505"},
+			status: http.StatusConflict,
+			body:   "A record with the same values already exists",
+		},
+		{
+			name:   "check violation",
+			err:    &pgconn.PgError{Code: "23514", ConstraintName: "memberships_role_check", TableName: "memberships"},
+			status: http.StatusUnprocessableEntity,
+			body:   "Invalid value for role",
+		},
+		{
+			name:   "not null violation",
+			err:    &pgconn.PgError{Code: "23502", ColumnName: "first_name", TableName: "users"},
+			status: http.StatusUnprocessableEntity,
+			body:   "Invalid value for first_name",
+		},
+		{
+			name:   "value too long",
+			err:    fmt.Errorf("failed to update user: %w", &pgconn.PgError{Code: "22001"}),
+			status: http.StatusUnprocessableEntity,
+			body:   "A value is invalid or too long",
+		},
+		{
+			name:   "not found",
+			err:    fmt.Errorf("failed to get user 7: %w", queries.ErrUserNotFound),
+			status: http.StatusNotFound,
+			body:   "User not found",
+		},
+		{
+			name:   "conflict",
+			err:    queries.ErrLastOwner,
+			status: http.StatusConflict,
+			body:   "Organization must keep at least one owner",
+		},
+		{
+			name:   "forbidden",
+			err:    fmt.Errorf("failed to accept invitation: %w", queries.ErrInvitationEmailMismatch),
+			status: http.StatusForbidden,
+			body:   "Invitation was sent to a different email",
+		},
+		{
+			name:   "serialization failure",
+			err:    &pgconn.PgError{Code: "40001"},
+			status: http.StatusInternalServerError,
+			body:   "Internal server error",
+		},
+		{
+			name:   "other error",
+			err:    errors.New("connection refused"),
+			status: http.StatusInternalServerError,
+			body:   "Internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		resp := httptest.NewRecorder()
+		writeQueryError(resp, tt.err, "Failed to run query")
+		if resp.Code != tt.status || resp.Body.String() != tt.body+"\n" {
+			t.Errorf("Expected status code %d and body %q for %s, got %d and %q\n", tt.status, tt.body, tt.name, resp.Code, resp.Body.String())
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// userETag is the strong entity tag of a user, which changes whenever the user's version does
+func userETag(user models.User) string {
+	return fmt.Sprintf(`"%d"`, user.Version)
+}
+
+// etagListMatches reports whether an If-None-Match style list of entity tags matches the etag using weak comparison
+func etagListMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseIfMatchVersion reads the user version an If-Match header refers to.
+// It returns ok false for "*", meaning any current version, and an error for weak, malformed or multiple entity tags.
+func parseIfMatchVersion(header string) (int, bool, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, false, nil
+	}
+
+	if strings.HasPrefix(header, "W/") {
+		return 0, false, fmt.Errorf("If-Match requires a strong entity tag")
+	}
+
+	unquoted, err := strconv.Unquote(header)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return 0, false, fmt.Errorf("If-Match must be a single entity tag returned in an ETag header")
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil {
+		return 0, false, fmt.Errorf("If-Match must be a single entity tag returned in an ETag header")
+	}
+
+	return version, true, nil
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import "testing"
+
+func TestParseIfMatchVersion(t *testing.T) {
+	tests := []struct {
+		header  string
+		version int
+		ok      bool
+		wantErr bool
+	}{
+		{header: `"3"`, version: 3, ok: true},
+		{header: ` "12" `, version: 12, ok: true},
+		{header: `*`, ok: false},
+		{header: `W/"3"`, wantErr: true},
+		{header: `3`, wantErr: true},
+		{header: "`3`", wantErr: true},
+		{header: `"abc"`, wantErr: true},
+		{header: `"3", "4"`, wantErr: true},
+		{header: ``, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		version, ok, err := parseIfMatchVersion(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected an error for If-Match %q, got version %d\n", tt.header, version)
+			}
+			continue
+		}
+		if err != nil || version != tt.version || ok != tt.ok {
+			t.Errorf("Expected version %d and ok %t for If-Match %q, got %d, %t and error %v\n", tt.version, tt.ok, tt.header, version, ok, err)
+		}
+	}
+}
+
+func TestETagListMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		match  bool
+	}{
+		{header: `"3"`, match: true},
+		{header: `W/"3"`, match: true},
+		{header: `"1", "3"`, match: true},
+		{header: `"1",W/"3"`, match: true},
+		{header: `*`, match: true},
+		{header: `"4"`, match: false},
+		{header: `"1", "2"`, match: false},
+		{header: `3`, match: false},
+		{header: ``, match: false},
+	}
+
+	for _, tt := range tests {
+		if match := etagListMatches(tt.header, `"3"`); match != tt.match {
+			t.Errorf("Expected If-None-Match %q matching %t, got %t\n", tt.header, tt.match, match)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/tokens"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
+)
+
+// invitationTTL is how long an invite link can be used for
+const invitationTTL = 7 * 24 * time.Hour
+
+type createInvitationRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// CreateInvitation invites someone by email to the caller's active organization, sending them a single use invite link.
+// Inviting the same email again replaces the earlier invitation, so only the latest link works.
+func CreateInvitation(invitationRepo repository.InvitationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+		membership, ok := currentMembership(w, r)
+		if !ok {
+			return
+		}
+
+		var req createInvitationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode create invitation request", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		email, err := validation.NormalizeEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Role == "" {
+			req.Role = models.OrgRoleMember
+		}
+		if req.Role != models.OrgRoleOwner && req.Role != models.OrgRoleAdmin && req.Role != models.OrgRoleMember {
+			http.Error(w, "Role must be owner, admin or member", http.StatusBadRequest)
+			return
+		}
+		if req.Role == models.OrgRoleOwner && membership.Role != models.OrgRoleOwner {
+			http.Error(w, "Only owners can invite owners", http.StatusForbidden)
+			return
+		}
+
+		scope := queries.ScopeForMembership(membership)
+		invitation, err := invitationRepo.CreateInvitation(r.Context(), scope, email, req.Role, user, invitationTTL)
+		if errors.Is(err, queries.ErrAlreadyMember) {
+			http.Error(w, "User is already a member of the organization", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to create invitation", "organization_id", membership.OrganizationID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err = json.NewEncoder(w).Encode(invitation); err != nil {
+			slog.Error("Failed to encode invitation", "error", err)
+		}
+
+		slog.Info("Invitation sent", "invitation_id", invitation.ID, "organization_id", membership.OrganizationID)
+	})
+}
+
+// GetInvitation shows the holder of an invite link who it is for and which organization it joins,
+// and whether they should log in to accept it or sign up
+func GetInvitation(invitationRepo repository.InvitationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		details, err := invitationRepo.GetInvitationDetails(r.Context(), tokens.Hash(r.PathValue("token")))
+		if errors.Is(err, queries.ErrInvitationNotFound) {
+			http.Error(w, "Invitation is invalid or has expired", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch invitation")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(details); err != nil {
+			slog.Error("Failed to encode invitation", "error", err)
+		}
+	})
+}
+
+// AcceptInvitation adds the logged in caller to the organization they were invited to
+func AcceptInvitation(invitationRepo repository.InvitationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		membership, err := invitationRepo.AcceptInvitation(r.Context(), tokens.Hash(r.PathValue("token")), user)
+		if errors.Is(err, queries.ErrInvitationNotFound) {
+			http.Error(w, "Invitation is invalid or has expired", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to accept invitation", "user_id", user.ID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(membership); err != nil {
+			slog.Error("Failed to encode membership", "error", err)
+		}
+
+		slog.Info("Invitation accepted", "user_id", user.ID, "organization_id", membership.OrganizationID)
+	})
+}
+
+// SignUpWithInvitation signs up someone without an account from their invite link. It takes the same form as SignUp,
+// except the email always comes from the invitation, and the new user starts out in the organization they were invited to.
+// Someone whose account was deleted signs up for a new account here, since the deleted one can't log in to accept.
+func SignUpWithInvitation(invitationRepo repository.InvitationRepository, sessionRepo repository.SessionRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenHash := tokens.Hash(r.PathValue("token"))
+
+		details, err := invitationRepo.GetInvitationDetails(r.Context(), tokenHash)
+		if errors.Is(err, queries.ErrInvitationNotFound) {
+			http.Error(w, "Invitation is invalid or has expired", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch invitation")
+			return
+		}
+
+		if details.ExistingUser {
+			http.Error(w, "An account already exists for this email, log in to accept the invitation", http.StatusConflict)
+			return
+		}
+
+		user, ok := newUserFromForm(w, r, details.Email)
+		if !ok {
+			return
+		}
+
+		userID, err := invitationRepo.SignUpInvitedUser(r.Context(), tokenHash, user)
+		if errors.Is(err, queries.ErrInvitationNotFound) {
+			http.Error(w, "Invitation is invalid or has expired", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, queries.ErrEmailTaken) {
+			http.Error(w, "An account already exists for this email, log in to accept the invitation", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to sign up invited user")
+			return
+		}
+
+		startSession(w, r, sessionRepo, userID, details.Email)
+	})
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// currentUser returns the authenticated caller, writing an error response and returning false if there is none
+func currentUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	user, ok := middleware.UserFromContext(r.Context())
+	if !ok {
+		slog.Error("Authenticated route reached without a user in context")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return models.User{}, false
+	}
+
+	return user, true
+}
+
+// RequestDataExport queues an export of everything stored about the caller, which can be downloaded from the returned location once ready
+func RequestDataExport(dbPool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		dataExport, err := queries.CreateDataExport(r.Context(), dbPool, user.ID)
+		if err != nil {
+			writeQueryError(w, err, "Failed to create data export", "user_id", user.ID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", fmt.Sprintf("/me/exports/%d", dataExport.ID))
+		w.WriteHeader(http.StatusAccepted)
+		if err = json.NewEncoder(w).Encode(dataExport); err != nil {
+			slog.Error("Failed to encode data export response", "error", err)
+			return
+		}
+
+		slog.Info("Data export requested", "id", dataExport.ID, "user_id", user.ID)
+	})
+}
+
+// GetDataExport returns the status of one of the caller's data exports, or the zip archive itself once it is ready
+func GetDataExport(dbPool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid export id", http.StatusBadRequest)
+			return
+		}
+
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		dataExport, err := queries.GetDataExport(r.Context(), dbPool, user.ID, id)
+		if errors.Is(err, queries.ErrDataExportNotFound) {
+			http.Error(w, "Export not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to get data export", "id", id)
+			return
+		}
+
+		if dataExport.Status != models.DataExportReady {
+			w.Header().Set("Content-Type", "application/json")
+			if dataExport.Status != models.DataExportFailed {
+				w.WriteHeader(http.StatusAccepted)
+			}
+			if err = json.NewEncoder(w).Encode(dataExport); err != nil {
+				slog.Error("Failed to encode data export response", "error", err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="data-export-%d.zip"`, dataExport.ID))
+		w.Header().Set("Content-Length", strconv.Itoa(len(dataExport.Archive)))
+		w.Write(dataExport.Archive)
+	})
+}
+
+// DeleteMe schedules the caller's account to be erased once the cooling off period has passed, until then it can be cancelled
+func DeleteMe(dbPool *pgxpool.Pool, coolingOff time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		scheduledAt, err := queries.ScheduleUserErasure(r.Context(), dbPool, user.ID, coolingOff)
+		if err != nil {
+			writeQueryError(w, err, "Failed to schedule user erasure", "user_id", user.ID)
+			return
+		}
+
+		response := map[string]string{
+			"erasure_scheduled_at": scheduledAt.Format(time.RFC3339),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err = json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to encode erasure response", "error", err)
+		}
+	})
+}
+
+func CancelErasure(dbPool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		err := queries.CancelUserErasure(r.Context(), dbPool, user.ID)
+		if errors.Is(err, queries.ErrUserNotFound) {
+			http.Error(w, "No erasure is scheduled", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to cancel user erasure", "user_id", user.ID)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// currentMembership returns the caller's membership of their active organization, writing an error response and
+// returning false if there is none
+func currentMembership(w http.ResponseWriter, r *http.Request) (models.Membership, bool) {
+	membership, ok := middleware.MembershipFromContext(r.Context())
+	if !ok {
+		slog.Error("Organization route reached without a membership in context")
+		http.Error(w, "No active organization, switch to an organization first", http.StatusForbidden)
+		return models.Membership{}, false
+	}
+
+	return membership, true
+}
+
+type createOrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// CreateOrganization creates an organization owned by the caller
+func CreateOrganization(orgRepo repository.OrganizationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req createOrganizationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode create organization request", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" || utf8.RuneCountInString(name) > 100 {
+			http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+			return
+		}
+		if len(req.Slug) > 63 || !organizationSlugPattern.MatchString(req.Slug) {
+			http.Error(w, "Slug must be at most 63 lowercase letters, digits and single hyphens", http.StatusBadRequest)
+			return
+		}
+
+		organization, err := orgRepo.CreateOrganization(r.Context(), name, req.Slug, user)
+		if errors.Is(err, queries.ErrSlugTaken) {
+			http.Error(w, "Slug is already taken", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to create organization", "user_id", user.ID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err = json.NewEncoder(w).Encode(organization); err != nil {
+			slog.Error("Failed to encode organization", "error", err)
+		}
+	})
+}
+
+// GetMyOrganizations lists the organizations the caller is a member of
+func GetMyOrganizations(orgRepo repository.OrganizationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		organizations, err := orgRepo.GetOrganizationsForUser(r.Context(), user.ID)
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch organizations", "user_id", user.ID)
+			return
+		}
+		if organizations == nil {
+			organizations = []models.UserOrganization{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(organizations); err != nil {
+			slog.Error("Failed to encode organizations", "error", err)
+		}
+	})
+}
+
+// SwitchOrganization makes one of the caller's organizations their active one and returns an access token carrying it.
+// The session of the refresh token cookie is switched too, so refreshed access tokens stay in the same organization.
+func SwitchOrganization(orgRepo repository.OrganizationRepository, sessionRepo repository.SessionRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentUser(w, r)
+		if !ok {
+			return
+		}
+
+		organizationID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "Invalid organization id", http.StatusBadRequest)
+			return
+		}
+
+		_, err = orgRepo.GetMembership(r.Context(), organizationID, user.ID)
+		if errors.Is(err, queries.ErrMembershipNotFound) {
+			http.Error(w, "Organization not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch membership", "user_id", user.ID, "organization_id", organizationID)
+			return
+		}
+
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			if claims, err := middleware.ParseToken(cookie.Value); err == nil && claims.Email == user.Email {
+				err = sessionRepo.SetSessionOrganization(r.Context(), claims.SessionID, user.ID, organizationID)
+				if err != nil {
+					slog.Warn("Failed to switch organization of session", "error", err, "session_id", claims.SessionID)
+				}
+			}
+		}
+
+		accessToken, err := middleware.CreateOrganizationAccessToken(user.Email, organizationID)
+		if err != nil {
+			slog.Error("Failed to create JWT token", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]any{
+			"token":           accessToken,
+			"organization_id": organizationID,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(response); err != nil {
+			slog.Error("Failed to encode switch organization response", "error", err)
+		}
+
+		slog.Info("User switched organization", "user_id", user.ID, "organization_id", organizationID)
+	})
+}
+
+// GetCurrentOrganization returns the caller's active organization along with their role in it
+func GetCurrentOrganization(orgRepo repository.OrganizationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		membership, ok := currentMembership(w, r)
+		if !ok {
+			return
+		}
+
+		organization, err := orgRepo.GetOrganization(r.Context(), queries.ScopeForMembership(membership))
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch organization", "organization_id", membership.OrganizationID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(models.UserOrganization{
+			Organization: organization,
+			Role:         membership.Role,
+			JoinedAt:     membership.CreatedAt,
+		})
+		if err != nil {
+			slog.Error("Failed to encode organization", "error", err)
+		}
+	})
+}
+
+// GetOrganizationMembers lists the members of the caller's active organization
+func GetOrganizationMembers(orgRepo repository.OrganizationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		membership, ok := currentMembership(w, r)
+		if !ok {
+			return
+		}
+
+		members, err := orgRepo.GetOrganizationMembers(r.Context(), queries.ScopeForMembership(membership))
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch organization members", "organization_id", membership.OrganizationID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(members); err != nil {
+			slog.Error("Failed to encode organization members", "error", err)
+		}
+	})
+}
+
+// UpdateOrganizationMember changes a member's role in the caller's active organization.
+// Admins can manage admins and members, while only owners can grant or take away ownership.
+func UpdateOrganizationMember(orgRepo repository.OrganizationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		membership, ok := currentMembership(w, r)
+		if !ok {
+			return
+		}
+
+		userID, err := strconv.Atoi(r.PathValue("user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode update member request", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Role != models.OrgRoleOwner && req.Role != models.OrgRoleAdmin && req.Role != models.OrgRoleMember {
+			http.Error(w, "Role must be owner, admin or member", http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeMemberChange(w, r, orgRepo, membership, userID, req.Role) {
+			return
+		}
+
+		actorEmail, _ := middleware.EmailFromContext(r.Context())
+		err = orgRepo.UpdateMemberRole(r.Context(), queries.ScopeForMembership(membership), userID, req.Role, actorEmail)
+		writeMemberChangeError(w, err, membership, userID)
+	})
+}
+
+// RemoveOrganizationMember removes a member from the caller's active organization. Any member can remove themselves,
+// other members can only be removed by admins, and owners only by other owners.
+func RemoveOrganizationMember(orgRepo repository.OrganizationRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		membership, ok := currentMembership(w, r)
+		if !ok {
+			return
+		}
+
+		userID, err := strconv.Atoi(r.PathValue("user_id"))
+		if err != nil {
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		if userID != membership.UserID && !authorizeMemberChange(w, r, orgRepo, membership, userID, "") {
+			return
+		}
+
+		actorEmail, _ := middleware.EmailFromContext(r.Context())
+		err = orgRepo.RemoveMember(r.Context(), queries.ScopeForMembership(membership), userID, actorEmail)
+		writeMemberChangeError(w, err, membership, userID)
+	})
+}
+
+// authorizeMemberChange checks the caller's role allows them to change the member, and to give them the new role if set
+func authorizeMemberChange(w http.ResponseWriter, r *http.Request, orgRepo repository.OrganizationRepository, actor models.Membership, userID int, newRole string) bool {
+	if actor.Role == models.OrgRoleOwner {
+		return true
+	}
+	if actor.Role != models.OrgRoleAdmin || newRole == models.OrgRoleOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	target, err := orgRepo.GetMembership(r.Context(), actor.OrganizationID, userID)
+	if errors.Is(err, queries.ErrMembershipNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to fetch membership", "error", err, "user_id", userID, "organization_id", actor.OrganizationID)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	if target.Role == models.OrgRoleOwner {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func writeMemberChangeError(w http.ResponseWriter, err error, actor models.Membership, userID int) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, queries.ErrMembershipNotFound):
+		http.Error(w, "Member not found", http.StatusNotFound)
+	case errors.Is(err, queries.ErrLastOwner):
+		http.Error(w, "The organization must keep at least one owner", http.StatusConflict)
+	default:
+		slog.Error("Failed to change organization member", "error", err, "user_id", userID, "organization_id", actor.OrganizationID)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
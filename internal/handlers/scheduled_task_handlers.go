@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/scheduler"
+)
+
+type scheduledTasksResponse struct {
+	// Leader reports whether the instance that served the request is the one running the tasks
+	Leader bool                   `json:"leader"`
+	Tasks  []scheduler.TaskStatus `json:"tasks"`
+}
+
+// GetScheduledTasks lists the scheduled tasks with their schedules, next runs and the outcome of their latest runs
+func GetScheduledTasks(s *scheduler.Scheduler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tasks, err := s.Status(r.Context())
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch scheduled task runs")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(scheduledTasksResponse{Leader: s.IsLeader(), Tasks: tasks}); err != nil {
+			slog.Error("Failed to encode scheduled tasks", "error", err)
+		}
+	})
+}
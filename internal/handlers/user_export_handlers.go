@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/attributes"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// exportFlushEvery is the number of rows written between flushes of the response
+const exportFlushEvery = 500
+
+var userExportCSVHeader = []string{"id", "first_name", "last_name", "email", "role", "created_at"}
+
+// ExportUsers streams the users matched by the same filters as GetUsers straight from the database cursor to the
+// response as csv, ndjson or json (?format=, defaults to ndjson), flushing as it goes so the table is never buffered
+func ExportUsers(userRepo repository.UserRepository, registry *attributes.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseUserFilter(r, registry)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "ndjson"
+		}
+
+		var contentType string
+		switch format {
+		case "csv":
+			contentType = "text/csv"
+		case "ndjson":
+			contentType = "application/x-ndjson"
+		case "json":
+			contentType = "application/json"
+		default:
+			http.Error(w, "format must be one of csv, ndjson or json", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+
+		rc := http.NewResponseController(w)
+		csvWriter := csv.NewWriter(w)
+		jsonEncoder := json.NewEncoder(w)
+		count := 0
+
+		if format == "csv" {
+			csvWriter.Write(userExportCSVHeader)
+		} else if format == "json" {
+			w.Write([]byte("["))
+		}
+
+		err = userRepo.StreamUsers(r.Context(), filter, func(user models.User) error {
+			var err error
+			switch format {
+			case "csv":
+				err = csvWriter.Write(userExportCSVRecord(user))
+			case "ndjson":
+				err = jsonEncoder.Encode(user)
+			case "json":
+				if count > 0 {
+					if _, err = w.Write([]byte(",")); err != nil {
+						return err
+					}
+				}
+				err = jsonEncoder.Encode(user)
+			}
+			if err != nil {
+				return err
+			}
+
+			count++
+			if count%exportFlushEvery == 0 {
+				csvWriter.Flush()
+				if err = csvWriter.Error(); err != nil {
+					return err
+				}
+				return rc.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			// Headers have already been sent, so abort the response rather than leaving a truncated export looking complete
+			slog.Error("Failed to stream users export", "error", err, "format", format, "rows_written", count)
+			panic(http.ErrAbortHandler)
+		}
+
+		if format == "json" {
+			w.Write([]byte("]"))
+		}
+		csvWriter.Flush()
+		rc.Flush()
+
+		slog.Info("Users exported successfully", "format", format, "count", count)
+	})
+}
+
+func userExportCSVRecord(user models.User) []string {
+	var firstName, lastName string
+	if user.FirstName != nil {
+		firstName = *user.FirstName
+	}
+	if user.LastName != nil {
+		lastName = *user.LastName
+	}
+
+	return []string{
+		strconv.Itoa(user.ID),
+		firstName,
+		lastName,
+		user.Email,
+		user.Role,
+		user.CreatedAt.Format(time.RFC3339),
+	}
+}
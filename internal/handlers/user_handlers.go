@@ -2,19 +2,33 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/anishsharma21/go-backend-starter-template/internal/attributes"
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
 	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
 )
 
-func GetUsers(dbPool *pgxpool.Pool) http.Handler {
+// GetUsers lists users, optionally filtered by the ids, email, created_after, created_before and attr.<name> query parameters
+func GetUsers(userRepo repository.UserRepository, registry *attributes.Registry) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		users, err := queries.GetAllUsers(r.Context(), dbPool)
+		filter, err := parseUserFilter(r, registry)
 		if err != nil {
-			slog.Error("Failed to fetch users", "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		users, err := userRepo.GetAllUsers(r.Context(), filter)
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch users")
 			return
 		}
 
@@ -29,15 +43,329 @@ func GetUsers(dbPool *pgxpool.Pool) http.Handler {
 	})
 }
 
-func DeleteUsers(dbPool *pgxpool.Pool) http.Handler {
+// parseUserFilter reads the user filter shared by the user listing and export endpoints from the query string
+func parseUserFilter(r *http.Request, registry *attributes.Registry) (queries.UserFilter, error) {
+	query := r.URL.Query()
+	filter := queries.UserFilter{
+		Email: strings.TrimSpace(query.Get("email")),
+	}
+
+	if ids := query.Get("ids"); ids != "" {
+		for _, idString := range strings.Split(ids, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idString))
+			if err != nil {
+				return queries.UserFilter{}, fmt.Errorf("Invalid user id %q in ids", idString)
+			}
+			filter.IDs = append(filter.IDs, id)
+		}
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return queries.UserFilter{}, fmt.Errorf("Invalid %s, expected an RFC 3339 timestamp", name)
+		}
+		*target = &t
+	}
+
+	for key, values := range query {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok {
+			continue
+		}
+		value, err := registry.ParseFilterValue(name, values[0])
+		if err != nil {
+			return queries.UserFilter{}, err
+		}
+		if filter.Attributes == nil {
+			filter.Attributes = map[string]any{}
+		}
+		filter.Attributes[name] = value
+	}
+
+	return filter, nil
+}
+
+// GetUser returns a single user with an ETag, answering 304 Not Modified when it matches If-None-Match
+func GetUser(userRepo repository.UserRepository) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := queries.DeleteAllUsers(r.Context(), dbPool)
+		id, err := strconv.Atoi(r.PathValue("id"))
 		if err != nil {
-			slog.Error("Failed to delete users", "error", err)
+			slog.Error("Invalid user id", "id", r.PathValue("id"), "error", err)
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		user, err := userRepo.GetUserByID(r.Context(), id)
+		if errors.Is(err, queries.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to fetch user", "id", id)
+			return
+		}
+
+		etag := userETag(user)
+		w.Header().Set("ETag", etag)
+
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagListMatches(ifNoneMatch, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(user); err != nil {
+			slog.Error("Failed to encode user", "error", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+	})
+}
 
+// GetUserAttributes returns the custom attributes declared in the schema registry
+func GetUserAttributes(registry *attributes.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(registry.Definitions()); err != nil {
+			slog.Error("Failed to encode user attributes", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// UpdateUser edits a user's name, role or custom attributes. The If-Match header must carry the ETag the change was based on,
+// so an update made from a stale copy of the user is rejected with 412 instead of overwriting someone else's change.
+// Attributes are merged into the existing ones, and setting an attribute to null removes it.
+func UpdateUser(userRepo repository.UserRepository, registry *attributes.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			slog.Error("Invalid user id", "id", r.PathValue("id"), "error", err)
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			http.Error(w, "If-Match header is required to update a user", http.StatusPreconditionRequired)
+			return
+		}
+
+		expectedVersion, hasVersion, err := parseIfMatchVersion(ifMatch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var update queries.UserUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			slog.Error("Failed to decode user update request", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if update.FirstName == nil && update.LastName == nil && update.Role == nil && update.Attributes == nil {
+			http.Error(w, "At least one of first_name, last_name, role or attributes is required", http.StatusBadRequest)
+			return
+		}
+		if update.Role != nil && *update.Role != models.RoleUser && *update.Role != models.RoleAdmin {
+			http.Error(w, fmt.Sprintf("Role must be %q or %q", models.RoleUser, models.RoleAdmin), http.StatusBadRequest)
+			return
+		}
+
+		// Attributes are merged into the version of the user the update is based on, and "If-Match: *" only
+		// requires the user to exist, so both need the current user. UpdateUser still rejects the change if
+		// the user is modified after it is read here.
+		if update.Attributes != nil || !hasVersion {
+			current, err := userRepo.GetUserByID(r.Context(), id)
+			if errors.Is(err, queries.ErrUserNotFound) && !hasVersion {
+				http.Error(w, "User not found", http.StatusPreconditionFailed)
+				return
+			}
+			if errors.Is(err, queries.ErrUserNotFound) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				writeQueryError(w, err, "Failed to fetch user", "id", id)
+				return
+			}
+
+			if !hasVersion {
+				expectedVersion = current.Version
+			}
+			if current.Version != expectedVersion {
+				w.Header().Set("ETag", userETag(current))
+				http.Error(w, "User has been modified since it was fetched", http.StatusPreconditionFailed)
+				return
+			}
+
+			if update.Attributes != nil {
+				update.Attributes, err = registry.Apply(current.Attributes, update.Attributes)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+					return
+				}
+			}
+		}
+
+		actorEmail, _ := middleware.EmailFromContext(r.Context())
+
+		user, err := userRepo.UpdateUser(r.Context(), id, expectedVersion, update, actorEmail)
+		if errors.Is(err, queries.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, queries.ErrUserVersionMismatch) {
+			w.Header().Set("ETag", userETag(user))
+			http.Error(w, "User has been modified since it was fetched", http.StatusPreconditionFailed)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to update user", "id", id)
+			return
+		}
+
+		w.Header().Set("ETag", userETag(user))
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(user); err != nil {
+			slog.Error("Failed to encode user", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+type bulkDeleteUsersRequest struct {
+	IDs     []int               `json:"ids"`
+	Filter  *queries.UserFilter `json:"filter"`
+	DryRun  bool                `json:"dry_run"`
+	Confirm bool                `json:"confirm"`
+}
+
+type bulkDeleteUsersResponse struct {
+	DryRun bool          `json:"dry_run"`
+	Count  int           `json:"count"`
+	Users  []models.User `json:"users"`
+}
+
+// DeleteUsers soft deletes the users matched by explicit ids and/or a filter.
+// A dry run returns the matched users without deleting them, otherwise confirm must be set.
+func DeleteUsers(userRepo repository.UserRepository, registry *attributes.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bulkDeleteUsersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("Failed to decode bulk delete request", "error", err)
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var filter queries.UserFilter
+		if req.Filter != nil {
+			filter = *req.Filter
+		}
+		if len(req.IDs) > 0 {
+			filter.IDs = req.IDs
+		}
+		if len(filter.Attributes) > 0 {
+			validated, err := registry.ValidateValues(filter.Attributes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter.Attributes = validated
+		}
+
+		if filter.IsEmpty() {
+			http.Error(w, "Either ids or a filter is required", http.StatusBadRequest)
+			return
+		}
+
+		if !req.DryRun && !req.Confirm {
+			http.Error(w, "Set confirm to true to delete users, or dry_run to preview them", http.StatusBadRequest)
+			return
+		}
+
+		actorEmail, _ := middleware.EmailFromContext(r.Context())
+
+		users, err := userRepo.BulkDeleteUsers(r.Context(), filter, actorEmail, req.DryRun)
+		if err != nil {
+			writeQueryError(w, err, "Failed to bulk delete users")
+			return
+		}
+		if users == nil {
+			users = []models.User{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(bulkDeleteUsersResponse{
+			DryRun: req.DryRun,
+			Count:  len(users),
+			Users:  users,
+		})
+		if err != nil {
+			slog.Error("Failed to encode bulk delete response", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+func DeleteUser(userRepo repository.UserRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			slog.Error("Invalid user id", "id", r.PathValue("id"), "error", err)
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		actorEmail, _ := middleware.EmailFromContext(r.Context())
+
+		err = userRepo.DeleteUserByID(r.Context(), id, actorEmail)
+		if errors.Is(err, queries.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to delete user", "id", id)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func RestoreUser(userRepo repository.UserRepository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			slog.Error("Invalid user id", "id", r.PathValue("id"), "error", err)
+			http.Error(w, "Invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		actorEmail, _ := middleware.EmailFromContext(r.Context())
+
+		err = userRepo.RestoreUserByID(r.Context(), id, actorEmail)
+		if errors.Is(err, queries.ErrUserNotFound) {
+			http.Error(w, "Deleted user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err, "Failed to restore user", "id", id)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	maxImportBytes = 10 << 20
+	maxImportRows  = 10000
+)
+
+type importUserRecord struct {
+	Email        string `json:"email"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Password     string `json:"password"`
+	PasswordHash string `json:"password_hash"`
+}
+
+type importRow struct {
+	row    int
+	record importUserRecord
+	err    error
+}
+
+type importRowError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+type importUsersResponse struct {
+	DryRun   bool             `json:"dry_run"`
+	Total    int              `json:"total"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []importRowError `json:"errors"`
+}
+
+// ImportUsers bulk creates users from a CSV (text/csv) or NDJSON (application/x-ndjson) upload.
+// CSV uploads need a header row naming the columns. Every row is validated and rows that fail validation or whose
+// email already exists are reported by row number instead of failing the whole import. Set ?dry_run=true to validate
+// and report without inserting anything.
+func ImportUsers(dbPool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+		var rows []importRow
+		var err error
+		switch mediaType {
+		case "text/csv":
+			rows, err = parseImportCSV(body)
+		case "application/x-ndjson", "application/ndjson":
+			rows, err = parseImportNDJSON(body)
+		default:
+			http.Error(w, "Content-Type must be text/csv or application/x-ndjson", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, fmt.Sprintf("Import must be smaller than %d bytes", maxImportBytes), http.StatusRequestEntityTooLarge)
+				return
+			}
+			slog.Error("Failed to parse user import", "error", err)
+			http.Error(w, fmt.Sprintf("Failed to parse import: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(rows) > maxImportRows {
+			http.Error(w, fmt.Sprintf("Import is limited to %d rows", maxImportRows), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		response := importUsersResponse{
+			DryRun: dryRun,
+			Total:  len(rows),
+			Errors: []importRowError{},
+		}
+
+		users, rowErrors := validateImportRows(rows, dryRun)
+		response.Errors = append(response.Errors, rowErrors...)
+
+		if len(users) > 0 {
+			actorEmail, _ := middleware.EmailFromContext(r.Context())
+			duplicateRows, err := queries.ImportUsers(r.Context(), dbPool, users, actorEmail, dryRun)
+			if err != nil {
+				writeQueryError(w, err, "Failed to import users")
+				return
+			}
+
+			emailsByRow := make(map[int]string, len(users))
+			for _, user := range users {
+				emailsByRow[user.Row] = user.User.Email
+			}
+			for _, row := range duplicateRows {
+				response.Errors = append(response.Errors, importRowError{
+					Row:   row,
+					Email: emailsByRow[row],
+					Error: "a user with this email already exists",
+				})
+			}
+			response.Imported = len(users) - len(duplicateRows)
+		}
+
+		response.Failed = len(response.Errors)
+		sort.Slice(response.Errors, func(i, j int) bool {
+			return response.Errors[i].Row < response.Errors[j].Row
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			slog.Error("Failed to encode import response", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("User import processed", "dry_run", dryRun, "total", response.Total, "imported", response.Imported, "failed", response.Failed)
+	})
+}
+
+func parseImportCSV(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header row: %v", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("header row must contain an email column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []importRow
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			rows = append(rows, importRow{row: row, err: parseErr.Err})
+			continue
+		}
+
+		rows = append(rows, importRow{
+			row: row,
+			record: importUserRecord{
+				Email:        field(record, "email"),
+				FirstName:    field(record, "first_name"),
+				LastName:     field(record, "last_name"),
+				Password:     field(record, "password"),
+				PasswordHash: field(record, "password_hash"),
+			},
+		})
+	}
+
+	return rows, nil
+}
+
+func parseImportNDJSON(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var rows []importRow
+	for row := 1; scanner.Scan(); row++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			row--
+			continue
+		}
+
+		var record importUserRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			rows = append(rows, importRow{row: row, err: fmt.Errorf("invalid JSON: %v", err)})
+			continue
+		}
+		record.Email = strings.TrimSpace(record.Email)
+		record.FirstName = strings.TrimSpace(record.FirstName)
+		record.LastName = strings.TrimSpace(record.LastName)
+
+		rows = append(rows, importRow{row: row, record: record})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// validateImportRows checks every row and hashes plain text passwords, returning the users that can be inserted and
+// an error for every row that cannot. A dry run inserts nothing, so its passwords are left unhashed.
+func validateImportRows(rows []importRow, dryRun bool) ([]queries.ImportUser, []importRowError) {
+	var users []queries.ImportUser
+	var rowErrors []importRowError
+	firstRowByEmail := make(map[string]int, len(rows))
+
+	for _, row := range rows {
+		err := row.err
+		if err == nil {
+			row.record.Email, err = validateImportRecord(row.record)
+		}
+		if err == nil {
+			if firstRow, ok := firstRowByEmail[row.record.Email]; ok {
+				err = fmt.Errorf("duplicate email, first seen on row %d", firstRow)
+			} else {
+				firstRowByEmail[row.record.Email] = row.row
+			}
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, importRowError{Row: row.row, Email: row.record.Email, Error: err.Error()})
+			continue
+		}
+
+		firstName, lastName, passwordHash := row.record.FirstName, row.record.LastName, row.record.PasswordHash
+		users = append(users, queries.ImportUser{
+			Row: row.row,
+			User: models.User{
+				Email:     row.record.Email,
+				FirstName: &firstName,
+				LastName:  &lastName,
+				Password:  &passwordHash,
+			},
+		})
+	}
+
+	if dryRun {
+		return users, rowErrors
+	}
+
+	users, hashErrors := hashImportPasswords(rows, users)
+
+	return users, append(rowErrors, hashErrors...)
+}
+
+// validateImportRecord checks the record and returns its normalized email
+func validateImportRecord(record importUserRecord) (string, error) {
+	if record.Email == "" || record.FirstName == "" || record.LastName == "" {
+		return record.Email, fmt.Errorf("email, first name and last name are required")
+	}
+
+	email, err := validation.NormalizeEmail(record.Email)
+	if err != nil {
+		return record.Email, err
+	}
+
+	if (record.Password == "") == (record.PasswordHash == "") {
+		return email, fmt.Errorf("exactly one of password or password_hash is required")
+	}
+
+	if record.Password != "" {
+		if err := validation.ValidatePassword(record.Password); err != nil {
+			return email, err
+		}
+	}
+
+	if record.PasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(record.PasswordHash)); err != nil {
+			return email, fmt.Errorf("password_hash is not a valid bcrypt hash")
+		}
+	}
+
+	return email, nil
+}
+
+// hashImportPasswords hashes the plain text passwords of the users concurrently since bcrypt is deliberately slow,
+// dropping any user whose password fails to hash
+func hashImportPasswords(rows []importRow, users []queries.ImportUser) ([]queries.ImportUser, []importRowError) {
+	passwordsByRow := make(map[int]string, len(rows))
+	for _, row := range rows {
+		if row.record.Password != "" {
+			passwordsByRow[row.row] = row.record.Password
+		}
+	}
+
+	hashErrs := make([]error, len(users))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for i := range users {
+		password, ok := passwordsByRow[users[i].Row]
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				hashErrs[i] = err
+				return
+			}
+			hashString := string(hash)
+			users[i].User.Password = &hashString
+		}(i)
+	}
+	wg.Wait()
+
+	hashed := users[:0]
+	var rowErrors []importRowError
+	for i, user := range users {
+		if hashErrs[i] != nil {
+			slog.Error("Failed to hash imported password", "error", hashErrs[i], "row", user.Row)
+			rowErrors = append(rowErrors, importRowError{Row: user.Row, Email: user.User.Email, Error: "failed to hash password"})
+			continue
+		}
+		hashed = append(hashed, user)
+	}
+
+	return hashed, rowErrors
+}
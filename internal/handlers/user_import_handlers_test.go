@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateImportRowsOnlyHashesPasswordsWhenImporting(t *testing.T) {
+	rows := []importRow{
+		{row: 1, record: importUserRecord{Email: "a@example.com", FirstName: "A", LastName: "User", Password: "Password123!"}},
+		{row: 2, record: importUserRecord{Email: "b@example.com", FirstName: "B", LastName: "User"}},
+	}
+
+	users, rowErrors := validateImportRows(rows, true)
+	if len(users) != 1 || len(rowErrors) != 1 || rowErrors[0].Row != 2 {
+		t.Fatalf("Expected row 1 to be valid and row 2 to fail, got %v and %v\n", users, rowErrors)
+	}
+	if *users[0].User.Password != "" {
+		t.Errorf("Expected a dry run to leave the password unhashed, got %q\n", *users[0].User.Password)
+	}
+
+	users, _ = validateImportRows(rows, false)
+	if len(users) != 1 || bcrypt.CompareHashAndPassword([]byte(*users[0].User.Password), []byte("Password123!")) != nil {
+		t.Errorf("Expected an import to hash the password, got %v\n", users)
+	}
+}
@@ -0,0 +1,95 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxDimension and MaxPixels guard against decompression bombs, small files that decode to enormous images
+	MaxDimension = 8192
+	MaxPixels    = 40_000_000
+)
+
+// ErrUnsupportedImage is returned for uploads that are not PNG, JPEG or GIF images
+var ErrUnsupportedImage = errors.New("image must be a PNG, JPEG or GIF")
+
+var supportedContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// SquareThumbnail decodes the image, crops it to a centred square and scales it to size x size pixels, returning it
+// re-encoded as a PNG. Re-encoding strips metadata such as EXIF location data and anything appended to the file.
+// The format is detected from the content itself, never from the file name or the client's content type.
+func SquareThumbnail(data []byte, size int) ([]byte, error) {
+	if !supportedContentTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxDimension || config.Height > MaxDimension || config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("image dimensions %dx%d are too large", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, scaleSquare(src, size)); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// scaleSquare crops the centre square of src and resamples it to size x size, averaging every source pixel that
+// falls inside each destination pixel so downscaled images stay smooth
+func scaleSquare(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for dy := 0; dy < size; dy++ {
+		sy0 := y0 + dy*side/size
+		sy1 := max(y0+(dy+1)*side/size, sy0+1)
+
+		for dx := 0; dx < size; dx++ {
+			sx0 := x0 + dx*side/size
+			sx1 := max(x0+(dx+1)*side/size, sx0+1)
+
+			var r, g, b, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			dst.Set(dx, dy, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
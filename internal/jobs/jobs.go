@@ -0,0 +1,202 @@
+// Package jobs runs background jobs queued in Postgres with queries.InsertJob or queries.EnqueueJob
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// jobTimeout is how long a job can run before its context is cancelled
+	jobTimeout = 5 * time.Minute
+	// jobStaleAfter is how long a job can be running before another worker assumes its worker died and claims it again
+	jobStaleAfter = 2 * jobTimeout
+
+	retryBaseDelay = 10 * time.Second
+	retryMaxDelay  = time.Hour
+)
+
+// Job is a claimed job with its arguments decoded
+type Job[T models.JobArgs] struct {
+	ID int64
+	// Attempt counts from 1 for the first time the job runs
+	Attempt     int
+	MaxAttempts int
+	Args        T
+}
+
+// LastAttempt reports whether the job won't be retried if this attempt fails
+func (j Job[T]) LastAttempt() bool {
+	return j.Attempt >= j.MaxAttempts
+}
+
+// permanentError marks a failure that retrying can't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps an error returned by a job handler so the job is marked dead straight away instead of retried
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Workers holds the handlers for each kind of job and runs the jobs they handle
+type Workers struct {
+	handlers map[string]func(ctx context.Context, job models.Job) error
+}
+
+func NewWorkers() *Workers {
+	return &Workers{handlers: map[string]func(ctx context.Context, job models.Job) error{}}
+}
+
+// Register handles jobs with arguments of type T, replacing any handler already registered for their kind
+func Register[T models.JobArgs](w *Workers, handler func(ctx context.Context, job Job[T]) error) {
+	var zero T
+	w.handlers[zero.Kind()] = func(ctx context.Context, job models.Job) error {
+		var args T
+		if err := json.Unmarshal(job.Args, &args); err != nil {
+			return Permanent(fmt.Errorf("failed to decode arguments: %w", err))
+		}
+
+		return handler(ctx, Job[T]{ID: job.ID, Attempt: job.Attempts, MaxAttempts: job.MaxAttempts, Args: args})
+	}
+}
+
+// Start claims due jobs of the registered kinds, running up to concurrency of them at once and polling for more on
+// every interval, until ctx is cancelled. Jobs already running are allowed to finish, and the returned channel is
+// closed once they have and the workers have stopped.
+func (w *Workers) Start(ctx context.Context, dbPool *pgxpool.Pool, concurrency int, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var running sync.WaitGroup
+		slots := make(chan struct{}, concurrency)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			w.claimJobs(ctx, dbPool, slots, &running)
+
+			select {
+			case <-ctx.Done():
+				running.Wait()
+				slog.Info("Job workers stopped.")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+// claimJobs claims as many jobs as there are free slots and starts them, repeating while it fills every slot
+func (w *Workers) claimJobs(ctx context.Context, dbPool *pgxpool.Pool, slots chan struct{}, running *sync.WaitGroup) {
+	kinds := slices.Sorted(maps.Keys(w.handlers))
+
+	for ctx.Err() == nil {
+		free := cap(slots) - len(slots)
+		if free == 0 {
+			return
+		}
+
+		claimed, err := queries.ClaimJobs(ctx, dbPool, kinds, free, jobStaleAfter)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to claim jobs", "error", err)
+			}
+			return
+		}
+
+		for _, job := range claimed {
+			slots <- struct{}{}
+			running.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					running.Done()
+				}()
+				// Jobs finish even when the server is shutting down, so they aren't left to go stale
+				w.run(context.WithoutCancel(ctx), dbPool, job)
+			}()
+		}
+
+		if len(claimed) < free {
+			return
+		}
+	}
+}
+
+// run runs the job's handler and then removes it, schedules a retry with exponential backoff, or marks it dead
+func (w *Workers) run(ctx context.Context, dbPool *pgxpool.Pool, job models.Job) {
+	started := time.Now()
+	err := w.handle(ctx, job)
+
+	if err == nil {
+		if err = queries.CompleteJob(ctx, dbPool, job.ID); err != nil {
+			slog.Error("Failed to complete job", "error", err, "id", job.ID, "kind", job.Kind)
+			return
+		}
+		slog.Info("Job completed", "id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "duration", time.Since(started))
+		return
+	}
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		slog.Error("Job failed and will not be retried", "error", err, "id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+		if err = queries.DeadLetterJob(ctx, dbPool, job.ID, err.Error()); err != nil {
+			slog.Error("Failed to mark job as dead", "error", err, "id", job.ID, "kind", job.Kind)
+		}
+		return
+	}
+
+	delay := retryDelay(job.Attempts)
+	slog.Warn("Job failed and will be retried", "error", err, "id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "delay", delay)
+	if err = queries.RetryJob(ctx, dbPool, job.ID, err.Error(), delay); err != nil {
+		slog.Error("Failed to reschedule job", "error", err, "id", job.ID, "kind", job.Kind)
+	}
+}
+
+// handle runs the job's handler with a timeout, turning a panic into an error
+func (w *Workers) handle(ctx context.Context, job models.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	handler, ok := w.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler registered for %s jobs", job.Kind))
+	}
+
+	return handler(ctx, job)
+}
+
+// retryDelay doubles the delay before retrying a job with every failed attempt, up to an hour
+func retryDelay(attempts int) time.Duration {
+	if attempts > 10 {
+		return retryMaxDelay
+	}
+	return min(retryBaseDelay<<(attempts-1), retryMaxDelay)
+}
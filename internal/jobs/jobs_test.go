@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+type testJob struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (testJob) Kind() string { return "tests.run" }
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{attempts: 1, delay: 10 * time.Second},
+		{attempts: 2, delay: 20 * time.Second},
+		{attempts: 3, delay: 40 * time.Second},
+		{attempts: 9, delay: 2560 * time.Second},
+		{attempts: 10, delay: time.Hour},
+		{attempts: 11, delay: time.Hour},
+		{attempts: 100, delay: time.Hour},
+	}
+
+	for _, tt := range tests {
+		if delay := retryDelay(tt.attempts); delay != tt.delay {
+			t.Errorf("Expected a delay of %v after %d attempts, got %v\n", tt.delay, tt.attempts, delay)
+		}
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	cause := errors.New("recipient does not exist")
+	err := Permanent(cause)
+
+	var permanent *permanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("Expected a permanent error, got %v\n", err)
+	}
+	if !errors.Is(err, cause) || err.Error() != cause.Error() {
+		t.Errorf("Expected the permanent error to wrap %q, got %q\n", cause, err)
+	}
+	if errors.As(cause, &permanent) {
+		t.Errorf("Expected an unwrapped error not to be permanent\n")
+	}
+}
+
+func TestRegisterDecodesArguments(t *testing.T) {
+	workers := NewWorkers()
+	var received Job[testJob]
+	Register(workers, func(ctx context.Context, job Job[testJob]) error {
+		received = job
+		if job.Args.Count < 0 {
+			panic("negative count")
+		}
+		return nil
+	})
+
+	args, _ := json.Marshal(testJob{Name: "widgets", Count: 3})
+	err := workers.handle(context.Background(), models.Job{ID: 7, Kind: "tests.run", Args: args, Attempts: 2, MaxAttempts: 2})
+	if err != nil {
+		t.Fatalf("Expected no error handling the job, got %v\n", err)
+	}
+	if received.ID != 7 || received.Args != (testJob{Name: "widgets", Count: 3}) || received.Attempt != 2 || !received.LastAttempt() {
+		t.Errorf("Expected the decoded job on its last attempt, got %+v\n", received)
+	}
+
+	var permanent *permanentError
+	err = workers.handle(context.Background(), models.Job{ID: 8, Kind: "tests.run", Args: json.RawMessage(`{"count": "three"}`), Attempts: 1, MaxAttempts: 5})
+	if !errors.As(err, &permanent) {
+		t.Errorf("Expected arguments that don't decode to fail permanently, got %v\n", err)
+	}
+
+	err = workers.handle(context.Background(), models.Job{ID: 9, Kind: "tests.unknown", Args: args, Attempts: 1, MaxAttempts: 5})
+	if !errors.As(err, &permanent) {
+		t.Errorf("Expected a job without a handler to fail permanently, got %v\n", err)
+	}
+
+	err = workers.handle(context.Background(), models.Job{ID: 10, Kind: "tests.run", Args: json.RawMessage(`{"count": -1}`), Attempts: 1, MaxAttempts: 5})
+	if err == nil || errors.As(err, &permanent) {
+		t.Errorf("Expected a panicking handler to fail and be retried, got %v\n", err)
+	}
+}
@@ -0,0 +1,89 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends transactional emails such as confirmations and notifications
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv returns an SMTP mailer when SMTP_HOST is set, otherwise a mailer that only logs messages for local development
+func NewFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		slog.Warn("SMTP_HOST not set, emails will be logged instead of sent")
+		return LogMailer{}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@" + host
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	return SMTPMailer{
+		Addr: net.JoinHostPort(host, port),
+		From: from,
+		Auth: auth,
+	}
+}
+
+// LogMailer writes messages to the logger instead of sending them
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Email sent", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("email recipient and subject must not contain line breaks")
+	}
+
+	body := strings.Join([]string{
+		"From: " + m.From,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		msg.Body,
+	}, "\r\n")
+
+	err := smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(body))
+	if err != nil {
+		return fmt.Errorf("failed to send email to %s: %v", msg.To, err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -9,6 +10,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -24,7 +28,7 @@ func init() {
 	JWT_SECRET_KEY = []byte(secretKeyString)
 }
 
-func JWTAuthMiddleware(next http.Handler) http.Handler {
+func JWTAuthMiddleware(userRepo repository.UserRepository, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
@@ -34,39 +38,126 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		_, err := VerifyToken(tokenString)
+		user, claims, err := AuthenticateToken(r.Context(), userRepo, tokenString)
 		if err != nil {
 			if errors.Is(err, jwt.ErrTokenExpired) {
 				http.Error(w, "Token expired", http.StatusUnauthorized)
 				return
 			}
+			if errors.Is(err, ErrTokenRevoked) {
+				http.Error(w, "Token revoked", http.StatusUnauthorized)
+				return
+			}
 			slog.Error("Error while verifying access token", "error", err, "access_token", tokenString)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// Refresh tokens outlive their session being revoked, so they are only accepted by the refresh endpoint,
+		// which checks the session is still active
+		if claims.SessionID != 0 {
+			slog.Warn("Refresh token used as access token", "user_id", user.ID, "session_id", claims.SessionID)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+
+		// The active organization is only trusted while the user is still a member of it
+		if claims.OrganizationID != 0 {
+			membership, err := userRepo.GetMembership(r.Context(), claims.OrganizationID, user.ID)
+			if err != nil && !errors.Is(err, queries.ErrMembershipNotFound) {
+				slog.Error("Failed to load membership of active organization", "error", err, "organization_id", claims.OrganizationID)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				ctx = context.WithValue(ctx, membershipContextKey, membership)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// ErrTokenRevoked is returned for validly signed tokens that no longer belong to an active user,
+// or that were issued before the user's tokens were invalidated, e.g. by an email change
+var ErrTokenRevoked = errors.New("token revoked")
+
+// AuthenticateToken verifies the token and loads the active user it was issued to
+func AuthenticateToken(ctx context.Context, userRepo repository.UserRepository, tokenString string) (models.User, *CustomClaims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	user, err := userRepo.GetUserByEmail(ctx, claims.Email)
+	if err != nil {
+		slog.Warn("No active user found for token", "error", err, "email", claims.Email)
+		return models.User{}, nil, ErrTokenRevoked
+	}
+
+	// Issued at is only precise to the second, so tokens issued in the same second as the invalidation are accepted
+	if user.TokensValidAfter != nil {
+		if claims.IssuedAt == nil || claims.IssuedAt.Time.Before(user.TokensValidAfter.Truncate(time.Second)) {
+			return models.User{}, nil, ErrTokenRevoked
+		}
+	}
+
+	return user, claims, nil
+}
+
+type contextKey string
+
+const (
+	userContextKey       contextKey = "user"
+	membershipContextKey contextKey = "membership"
+)
+
+// UserFromContext returns the authenticated caller set by JWTAuthMiddleware
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(models.User)
+	return user, ok
+}
+
+// EmailFromContext returns the email of the authenticated caller set by JWTAuthMiddleware
+func EmailFromContext(ctx context.Context) (string, bool) {
+	user, ok := UserFromContext(ctx)
+	return user.Email, ok && user.Email != ""
+}
+
+// RefreshTokenTTL is how long refresh tokens, and the sessions they belong to, last
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
 func CreateAccessToken(email string) (string, error) {
-	return createToken(email, time.Now().Add(15*time.Minute))
+	return CreateOrganizationAccessToken(email, 0)
 }
 
-func CreateRefreshToken(email string) (string, error) {
-	return createToken(email, time.Now().Add(7*24*time.Hour))
+// CreateOrganizationAccessToken creates an access token that carries the organization the user is working in
+func CreateOrganizationAccessToken(email string, organizationID int) (string, error) {
+	return createToken(email, 0, organizationID, time.Now().Add(15*time.Minute))
+}
+
+// CreateRefreshToken creates a refresh token tied to the session, so it stops working once the session is revoked
+func CreateRefreshToken(email string, sessionID int64) (string, error) {
+	return createToken(email, sessionID, 0, time.Now().Add(RefreshTokenTTL))
 }
 
 type CustomClaims struct {
-	Email string `json:"email"`
+	Email          string `json:"email"`
+	SessionID      int64  `json:"sid,omitempty"`
+	OrganizationID int    `json:"org,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func createToken(email string, expiration time.Time) (string, error) {
+func createToken(email string, sessionID int64, organizationID int, expiration time.Time) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, CustomClaims{
 		email,
+		sessionID,
+		organizationID,
 		jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expiration),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	})
 
@@ -79,7 +170,17 @@ func createToken(email string, expiration time.Time) (string, error) {
 }
 
 func VerifyToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Email, nil
+}
+
+// ParseToken verifies the signature and expiry of the token and returns its claims
+func ParseToken(tokenString string) (*CustomClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v\n", t.Header["alg"])
 		}
@@ -88,22 +189,21 @@ func VerifyToken(tokenString string) (string, error) {
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || !token.Valid {
-		return "", fmt.Errorf("invalid token claims")
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	email, ok := claims["email"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid token claims: email not found")
+	if claims.Email == "" {
+		return nil, fmt.Errorf("invalid token claims: email not found")
 	}
 
-	return email, nil
+	return claims, nil
 }
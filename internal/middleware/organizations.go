@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// MembershipFromContext returns the caller's membership of the active organization in their access token,
+// which JWTAuthMiddleware only sets while they are still a member of it
+func MembershipFromContext(ctx context.Context) (models.Membership, bool) {
+	membership, ok := ctx.Value(membershipContextKey).(models.Membership)
+	return membership, ok
+}
+
+// OrgRoleMiddleware rejects callers without an active organization, or whose role in it is not one of the roles.
+// With no roles any member is allowed. It must be wrapped by JWTAuthMiddleware.
+func OrgRoleMiddleware(next http.Handler, roles ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		membership, ok := MembershipFromContext(r.Context())
+		if !ok {
+			http.Error(w, "No active organization, switch to an organization first", http.StatusForbidden)
+			return
+		}
+
+		if len(roles) > 0 && !slices.Contains(roles, membership.Role) {
+			slog.Warn("Organization member attempted to access route without the required role", "user_id", membership.UserID, "organization_id", membership.OrganizationID, "role", membership.Role, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
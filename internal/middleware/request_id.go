@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/audit"
+)
+
+// requestIDPattern limits request ids passed in by clients or proxies to something safe to log and store
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware gives every request an id, reusing a valid X-Request-ID header if there is one, and echoes it back
+// in the response. The id and the client's IP address are recorded on any audit events written while handling the request.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				slog.Error("Failed to generate request id", "error", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			requestID = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := audit.WithMetadata(r.Context(), audit.Metadata{
+			RequestID: requestID,
+			IPAddress: ClientIP(r),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the IP address of the client the request came from, which is recorded on sessions and audit events
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
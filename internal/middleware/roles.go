@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// AdminOnlyMiddleware rejects callers who are not admins, it must be wrapped by JWTAuthMiddleware
+func AdminOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := UserFromContext(r.Context())
+		if !ok {
+			slog.Error("Admin only route reached without an authenticated user")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if user.Role != models.RoleAdmin {
+			slog.Warn("Non admin user attempted to access admin only route", "email", user.Email, "path", r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,81 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// Handler processes an event received from the bus. Returning an error makes the relay deliver the event again later.
+type Handler func(ctx context.Context, event models.OutboxEvent) error
+
+// Bus is an in-process sink that delivers events to the handlers subscribed to their subject, which is the event type.
+// Subjects are matched like NATS subjects: tokens are separated by dots, * matches a single token and > matches
+// one or more trailing tokens, so users.* and > both match users.created.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions map[int]subscription
+	nextID        int
+}
+
+type subscription struct {
+	tokens  []string
+	handler Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{subscriptions: map[int]subscription{}}
+}
+
+// Subscribe calls the handler for every event whose subject matches and returns a function that unsubscribes it
+func (b *Bus) Subscribe(subject string, handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	id := b.nextID
+	b.subscriptions[id] = subscription{tokens: strings.Split(subject, "."), handler: handler}
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscriptions, id)
+	}
+}
+
+// Publish calls every matching handler in turn, returning their errors once all of them have run
+func (b *Bus) Publish(ctx context.Context, event models.OutboxEvent) error {
+	subject := strings.Split(event.EventType, ".")
+
+	b.mu.RLock()
+	var handlers []Handler
+	for _, sub := range b.subscriptions {
+		if subjectMatches(sub.tokens, subject) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func subjectMatches(pattern, subject []string) bool {
+	for i, token := range pattern {
+		if token == ">" {
+			return len(subject) > i
+		}
+		if i >= len(subject) || (token != "*" && token != subject[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(subject)
+}
@@ -0,0 +1,73 @@
+// Package outbox delivers the domain events written to the outbox table to the systems that want to know about them
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// Sink is somewhere outbox events are delivered to. Delivery is at least once, so an event can be published again
+// after a failure or crash and sinks should pass the event id on for consumers to skip events they've already seen.
+type Sink interface {
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// LogSink writes every event to the application log
+type LogSink struct{}
+
+func (LogSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	slog.Info("Outbox event published", "id", event.ID, "event_type", event.EventType,
+		"aggregate_type", event.AggregateType, "aggregate_id", event.AggregateID, "payload", event.Payload)
+	return nil
+}
+
+// Multi publishes every event to all of the sinks, failing if any of them fails so the event is retried on all of them
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewSinksFromEnv returns the sinks listed in OUTBOX_SINKS, a comma separated list of log and webhook that defaults
+// to log, followed by the in-process bus
+func NewSinksFromEnv(bus *Bus) ([]Sink, error) {
+	names := os.Getenv("OUTBOX_SINKS")
+	if names == "" {
+		names = "log"
+	}
+
+	var sinks []Sink
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "log":
+			sinks = append(sinks, LogSink{})
+		case "webhook":
+			url := os.Getenv("OUTBOX_WEBHOOK_URL")
+			if url == "" {
+				return nil, fmt.Errorf("OUTBOX_WEBHOOK_URL must be set to use the webhook outbox sink")
+			}
+			sinks = append(sinks, NewWebhookSink(url, os.Getenv("OUTBOX_WEBHOOK_SECRET")))
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q in OUTBOX_SINKS", name)
+		}
+	}
+
+	return append(sinks, bus), nil
+}
@@ -0,0 +1,131 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/audit"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const auditEventColumns = `id, actor_email, action, target, details, ip_address, request_id, before, after, created_at`
+
+// InsertAuditEvent records an audit event inside the given transaction so it is only persisted if the audited change commits.
+// The request id and IP address are taken from the context's audit metadata unless the event already has them.
+func InsertAuditEvent(ctx context.Context, tx pgx.Tx, event models.AuditEvent) error {
+	if event.Details == nil {
+		event.Details = map[string]any{}
+	}
+
+	metadata := audit.MetadataFromContext(ctx)
+	if event.RequestID == nil && metadata.RequestID != "" {
+		event.RequestID = &metadata.RequestID
+	}
+	if event.IPAddress == nil && metadata.IPAddress != "" {
+		event.IPAddress = &metadata.IPAddress
+	}
+
+	args := pgx.NamedArgs{
+		"actor_email": event.ActorEmail,
+		"action":      event.Action,
+		"target":      event.Target,
+		"details":     event.Details,
+		"ip_address":  event.IPAddress,
+		"request_id":  event.RequestID,
+		"before":      event.Before,
+		"after":       event.After,
+	}
+
+	query := `
+		INSERT INTO audit_events (actor_email, action, target, details, ip_address, request_id, before, after)
+		VALUES (@actor_email, @action, @target, @details, @ip_address, @request_id, @before, @after)`
+
+	_, err := tx.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event %q: %w", event.Action, err)
+	}
+
+	return nil
+}
+
+// AuditEventFilter narrows down the audit events returned by GetAuditEvents, zero valued fields are ignored
+type AuditEventFilter struct {
+	ActorEmail string
+	// Action matches exactly, or every action under a prefix ending in a dot such as "users."
+	Action        string
+	Target        string
+	RequestID     string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// BeforeID only returns events older than the event with this id, to page through results
+	BeforeID int64
+}
+
+// GetAuditEvents returns up to limit audit events matching the filter, newest first
+func GetAuditEvents(ctx context.Context, dbPool *pgxpool.Pool, filter AuditEventFilter, limit int) ([]models.AuditEvent, error) {
+	conditions := []string{"TRUE"}
+	args := pgx.NamedArgs{"limit": limit}
+
+	if filter.ActorEmail != "" {
+		conditions = append(conditions, "lower(actor_email) = lower(@actor_email)")
+		args["actor_email"] = filter.ActorEmail
+	}
+	if strings.HasSuffix(filter.Action, ".") {
+		conditions = append(conditions, "action LIKE @action")
+		args["action"] = likeEscaper.Replace(filter.Action) + "%"
+	} else if filter.Action != "" {
+		conditions = append(conditions, "action = @action")
+		args["action"] = filter.Action
+	}
+	if filter.Target != "" {
+		conditions = append(conditions, "target = @target")
+		args["target"] = filter.Target
+	}
+	if filter.RequestID != "" {
+		conditions = append(conditions, "request_id = @request_id")
+		args["request_id"] = filter.RequestID
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= @created_after")
+		args["created_after"] = *filter.CreatedAfter
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < @created_before")
+		args["created_before"] = *filter.CreatedBefore
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "id < @before_id")
+		args["before_id"] = filter.BeforeID
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE ` + strings.Join(conditions, " AND ") + ` ORDER BY id DESC LIMIT @limit`
+
+	rows, err := dbPool.Query(ctx, query, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve audit events: %w", err)
+	}
+
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.AuditEvent])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect audit events: %w", err)
+	}
+
+	return events, nil
+}
+
+// PurgeAuditEvents removes audit events older than the retention period, which can't be shorter than a day. Audit events
+// are append only to the application, so they are removed by the purge_audit_events database function, which runs as
+// the role that owns the table.
+func PurgeAuditEvents(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) (int64, error) {
+	var purged int64
+	err := dbPool.QueryRow(ctx, `SELECT purge_audit_events($1::interval)`, retention).Scan(&purged)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit events: %w", err)
+	}
+
+	return purged, nil
+}
@@ -2,64 +2,97 @@ package queries
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// GetUserByEmail returns the active user with the email, compared case insensitively, or ErrUserNotFound
 func GetUserByEmail(ctx context.Context, dbPool *pgxpool.Pool, email string) (models.User, error) {
-	query := "SELECT * from users WHERE email = $1"
+	query := "SELECT * from users WHERE lower(email) = lower($1) AND deleted_at IS NULL"
 
 	rows, err := dbPool.Query(ctx, query, email)
 	if err != nil {
-		return models.User{}, fmt.Errorf("failed to retrieve data for user with email %q: %v", email, err)
+		return models.User{}, fmt.Errorf("failed to retrieve data for user with email %q: %w", email, err)
 	}
 	defer rows.Close()
 
 	user, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.User])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.User{}, ErrUserNotFound
+	}
 	if err != nil {
-		return models.User{}, fmt.Errorf("failed to collect data from database for user with email %q: %v", email, err)
+		return models.User{}, fmt.Errorf("failed to collect data from database for user with email %q: %w", email, err)
 	}
 
 	return user, nil
 }
 
-func SignUpNewUser(ctx context.Context, dbPool *pgxpool.Pool, user models.User) error {
+// SignUpNewUser inserts the user and returns their id, or ErrEmailTaken if an account already uses the email
+func SignUpNewUser(ctx context.Context, dbPool *pgxpool.Pool, user models.User) (int, error) {
+	var id int
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		id, err = InsertUser(ctx, tx, user)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slog.Info(fmt.Sprintf("User signed up successfully: %s", user.Email), "id", id)
+
+	return id, nil
+}
+
+// InsertUser inserts the user in the transaction along with a users.created outbox event and returns their id,
+// or ErrEmailTaken if an account already uses the email. The role defaults to a plain user and the attributes, which
+// must already be validated against the registry, to none.
+func InsertUser(ctx context.Context, tx pgx.Tx, user models.User) (int, error) {
 	args := pgx.NamedArgs{
 		"email":      user.Email,
 		"first_name": user.FirstName,
 		"last_name":  user.LastName,
 		"password":   user.Password,
+		"role":       nil,
+		"attributes": nil,
+	}
+	if user.Role != "" {
+		args["role"] = user.Role
+	}
+	if len(user.Attributes) > 0 {
+		args["attributes"] = user.Attributes
 	}
 
-	query := "INSERT INTO users (email, first_name, last_name, password) VALUES (@email, @first_name, @last_name, @password)"
+	query := `
+		INSERT INTO users (email, first_name, last_name, password, role, attributes)
+		VALUES (@email, @first_name, @last_name, @password, COALESCE(@role, 'user'), COALESCE(@attributes::jsonb, '{}'::jsonb))
+		RETURNING id`
 
-	tx, err := dbPool.Begin(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to start transaction: %v", err)
+	var id int
+	err := tx.QueryRow(ctx, query, args).Scan(&id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return 0, ErrEmailTaken
 	}
-	defer tx.Rollback(ctx)
-
-	ct, err := tx.Exec(ctx, query, args)
 	if err != nil {
-		tx.Rollback(ctx)
-		return fmt.Errorf("failed to execute query: %v", err)
-	}
-
-	if ct.RowsAffected() != 1 {
-		tx.Rollback(ctx)
-		return fmt.Errorf("failed to insert user: %v", err)
+		return 0, fmt.Errorf("failed to insert user: %w", err)
 	}
 
-	err = tx.Commit(ctx)
+	err = insertUserEvent(ctx, tx, id, UserCreatedEvent, map[string]any{
+		"id":         id,
+		"email":      user.Email,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return 0, err
 	}
 
-	slog.Info(fmt.Sprintf("User signed up successfully: %s", user.Email), "command_tag", ct.String())
-
-	return nil
+	return id, nil
 }
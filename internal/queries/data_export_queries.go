@@ -0,0 +1,170 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrDataExportNotFound is returned when a data export does not exist or belongs to another user
+var ErrDataExportNotFound = newKindError(ErrNotFound, "data export not found")
+
+const dataExportColumns = `id, user_id, status, error, created_at, started_at, completed_at`
+
+// CreateDataExport records a data export for the user and queues the job that generates its archive
+func CreateDataExport(ctx context.Context, dbPool *pgxpool.Pool, userID int) (models.DataExport, error) {
+	query := `INSERT INTO data_exports (user_id) VALUES ($1) RETURNING ` + dataExportColumns
+
+	var dataExport models.DataExport
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, query, userID)
+		if err != nil {
+			return fmt.Errorf("failed to create data export for user %d: %w", userID, err)
+		}
+
+		dataExport, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.DataExport])
+		if err != nil {
+			return fmt.Errorf("failed to collect created data export for user %d: %w", userID, err)
+		}
+
+		_, err = InsertJob(ctx, tx, models.DataExportJob{DataExportID: dataExport.ID}, JobOptions{UniqueKey: strconv.FormatInt(dataExport.ID, 10)})
+		return err
+	})
+	if err != nil {
+		return models.DataExport{}, err
+	}
+
+	return dataExport, nil
+}
+
+// GetDataExport returns the user's data export including its archive
+func GetDataExport(ctx context.Context, dbPool *pgxpool.Pool, userID int, id int64) (models.DataExport, error) {
+	query := `SELECT ` + dataExportColumns + `, archive FROM data_exports WHERE id = $1 AND user_id = $2`
+
+	rows, err := dbPool.Query(ctx, query, id, userID)
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to retrieve data export %d: %w", id, err)
+	}
+
+	dataExport, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.DataExport])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.DataExport{}, ErrDataExportNotFound
+	}
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to collect data export %d: %w", id, err)
+	}
+
+	return dataExport, nil
+}
+
+// StartDataExport marks an unfinished data export as processing and returns it, or ErrDataExportNotFound if there is
+// no unfinished export with the id, e.g. because it was already generated or its user was purged
+func StartDataExport(ctx context.Context, dbPool *pgxpool.Pool, id int64) (models.DataExport, error) {
+	query := `
+		UPDATE data_exports SET status = 'processing', started_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status IN ('pending', 'processing')
+		RETURNING ` + dataExportColumns
+
+	rows, err := dbPool.Query(ctx, query, id)
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to start data export %d: %w", id, err)
+	}
+
+	dataExport, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.DataExport])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.DataExport{}, ErrDataExportNotFound
+	}
+	if err != nil {
+		return models.DataExport{}, fmt.Errorf("failed to collect started data export %d: %w", id, err)
+	}
+
+	return dataExport, nil
+}
+
+func CompleteDataExport(ctx context.Context, dbPool *pgxpool.Pool, id int64, archive []byte) error {
+	query := `UPDATE data_exports SET status = 'ready', archive = $2, error = NULL, completed_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	_, err := dbPool.Exec(ctx, query, id, archive)
+	if err != nil {
+		return fmt.Errorf("failed to complete data export %d: %w", id, err)
+	}
+
+	return nil
+}
+
+func FailDataExport(ctx context.Context, dbPool *pgxpool.Pool, id int64, reason string) error {
+	query := `UPDATE data_exports SET status = 'failed', error = $2, completed_at = CURRENT_TIMESTAMP WHERE id = $1`
+
+	_, err := dbPool.Exec(ctx, query, id, reason)
+	if err != nil {
+		return fmt.Errorf("failed to mark data export %d as failed: %w", id, err)
+	}
+
+	return nil
+}
+
+// PurgeExpiredDataExports removes finished data exports, and the archives they hold, once they are older than the retention period
+func PurgeExpiredDataExports(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) (int64, error) {
+	query := `DELETE FROM data_exports WHERE completed_at < CURRENT_TIMESTAMP - $1::interval`
+
+	ct, err := dbPool.Exec(ctx, query, retention)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired data exports: %w", err)
+	}
+
+	return ct.RowsAffected(), nil
+}
+
+// GetUserData assembles everything stored about the user for a data export
+func GetUserData(ctx context.Context, dbPool *pgxpool.Pool, userID int) (models.UserData, error) {
+	rows, err := dbPool.Query(ctx, `SELECT `+userColumns+`, erasure_scheduled_at FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return models.UserData{}, fmt.Errorf("failed to retrieve profile for user %d: %w", userID, err)
+	}
+
+	profile, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.User])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.UserData{}, ErrUserNotFound
+	}
+	if err != nil {
+		return models.UserData{}, fmt.Errorf("failed to collect profile for user %d: %w", userID, err)
+	}
+
+	sessions, err := GetSessionsForUser(ctx, dbPool, userID)
+	if err != nil {
+		return models.UserData{}, err
+	}
+
+	rows, err = dbPool.Query(ctx, `SELECT `+auditEventColumns+` FROM audit_events WHERE actor_email = $1 OR target = $2 ORDER BY id`, profile.Email, fmt.Sprintf("user:%d", userID))
+	if err != nil {
+		return models.UserData{}, fmt.Errorf("failed to retrieve audit events for user %d: %w", userID, err)
+	}
+
+	auditEvents, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[models.AuditEvent])
+	if err != nil {
+		return models.UserData{}, fmt.Errorf("failed to collect audit events for user %d: %w", userID, err)
+	}
+
+	rows, err = dbPool.Query(ctx, `SELECT `+dataExportColumns+` FROM data_exports WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return models.UserData{}, fmt.Errorf("failed to retrieve data exports for user %d: %w", userID, err)
+	}
+
+	dataExports, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[models.DataExport])
+	if err != nil {
+		return models.UserData{}, fmt.Errorf("failed to collect data exports for user %d: %w", userID, err)
+	}
+
+	return models.UserData{
+		Profile:     profile,
+		Sessions:    sessions,
+		AuditEvents: auditEvents,
+		DataExports: dataExports,
+	}, nil
+}
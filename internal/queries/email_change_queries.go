@@ -0,0 +1,164 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrEmailChangeNotFound is returned when an email change token is unknown, expired or already used
+	ErrEmailChangeNotFound = newKindError(ErrNotFound, "email change request not found")
+	// ErrEmailTaken is returned when another user already has the email
+	ErrEmailTaken = newKindError(ErrConflict, "email already taken")
+)
+
+// EmailExists reports whether an active user has the email, soft deleted users don't hold on to theirs
+func EmailExists(ctx context.Context, dbPool *pgxpool.Pool, email string) (bool, error) {
+	var exists bool
+	err := dbPool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL)`, email).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether email %q exists: %w", email, err)
+	}
+
+	return exists, nil
+}
+
+// CreateEmailChangeRequest stores a pending change of the user's email, replacing any earlier unconfirmed request, and
+// queues the job that emails its confirmation in the same transaction
+func CreateEmailChangeRequest(ctx context.Context, dbPool *pgxpool.Pool, userID int, newEmail string, ttl time.Duration) error {
+	return WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM email_change_requests WHERE user_id = $1 AND confirmed_at IS NULL`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to remove previous email change requests: %w", err)
+		}
+
+		args := pgx.NamedArgs{
+			"user_id":   userID,
+			"new_email": newEmail,
+			"ttl":       ttl,
+		}
+		query := `INSERT INTO email_change_requests (user_id, new_email, expires_at) VALUES (@user_id, @new_email, CURRENT_TIMESTAMP + @ttl::interval) RETURNING id`
+
+		var id int64
+		err = tx.QueryRow(ctx, query, args).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to create email change request: %w", err)
+		}
+
+		_, err = InsertJob(ctx, tx, models.EmailChangeConfirmationJob{EmailChangeRequestID: id}, JobOptions{UniqueKey: strconv.FormatInt(id, 10)})
+		return err
+	})
+}
+
+// IssueEmailChangeToken sets the hash of the token that confirms a pending email change, replacing any token issued
+// for it before, and returns the request. It returns ErrEmailChangeNotFound once the request is confirmed, expired
+// or replaced.
+func IssueEmailChangeToken(ctx context.Context, dbPool *pgxpool.Pool, id int64, tokenHash string) (models.EmailChangeRequest, error) {
+	query := `
+		UPDATE email_change_requests SET token_hash = $2
+		WHERE id = $1 AND confirmed_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, user_id, new_email, token_hash, expires_at, confirmed_at, created_at`
+
+	rows, err := dbPool.Query(ctx, query, id, tokenHash)
+	if err != nil {
+		return models.EmailChangeRequest{}, fmt.Errorf("failed to issue token for email change request %d: %w", id, err)
+	}
+
+	request, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.EmailChangeRequest])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.EmailChangeRequest{}, ErrEmailChangeNotFound
+	}
+	if err != nil {
+		return models.EmailChangeRequest{}, fmt.Errorf("failed to collect email change request %d: %w", id, err)
+	}
+
+	return request, nil
+}
+
+// ConfirmEmailChange swaps the user's email for the one in the pending request and invalidates every token issued
+// for the old email. It returns the user's previous email so they can be notified of the change.
+func ConfirmEmailChange(ctx context.Context, dbPool *pgxpool.Pool, tokenHash string) (string, models.EmailChangeRequest, error) {
+	var oldEmail string
+	var request models.EmailChangeRequest
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		query := `
+			SELECT id, user_id, new_email, token_hash, expires_at, confirmed_at, created_at FROM email_change_requests
+			WHERE token_hash = $1 AND confirmed_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+			FOR UPDATE`
+
+		rows, err := tx.Query(ctx, query, tokenHash)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve email change request: %w", err)
+		}
+
+		request, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.EmailChangeRequest])
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrEmailChangeNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to collect email change request: %w", err)
+		}
+
+		err = tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, request.UserID).Scan(&oldEmail)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrEmailChangeNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock user %d: %w", request.UserID, err)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE users SET email = $2, tokens_valid_after = CURRENT_TIMESTAMP WHERE id = $1`, request.UserID, request.NewEmail)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				return ErrEmailTaken
+			}
+			return fmt.Errorf("failed to update email for user %d: %w", request.UserID, err)
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE email_change_requests SET confirmed_at = CURRENT_TIMESTAMP WHERE id = $1`, request.ID)
+		if err != nil {
+			return fmt.Errorf("failed to mark email change request as confirmed: %w", err)
+		}
+
+		err = insertUserEvent(ctx, tx, request.UserID, UserUpdatedEvent, map[string]any{"id": request.UserID, "changes": map[string]any{"email": request.NewEmail}})
+		if err != nil {
+			return err
+		}
+
+		target := fmt.Sprintf("user:%d", request.UserID)
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &request.NewEmail,
+			Action:     "users.change_email",
+			Target:     &target,
+			Before:     map[string]any{"email": oldEmail},
+			After:      map[string]any{"email": request.NewEmail},
+		})
+	})
+	if err != nil {
+		return "", models.EmailChangeRequest{}, err
+	}
+
+	slog.Info("User email changed", "id", request.UserID)
+
+	return oldEmail, request, nil
+}
+
+// PurgeExpiredEmailChangeRequests removes email change requests that can no longer be confirmed
+func PurgeExpiredEmailChangeRequests(ctx context.Context, dbPool *pgxpool.Pool) (int64, error) {
+	ct, err := dbPool.Exec(ctx, `DELETE FROM email_change_requests WHERE confirmed_at IS NULL AND expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired email change requests: %w", err)
+	}
+
+	return ct.RowsAffected(), nil
+}
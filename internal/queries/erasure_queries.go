@@ -0,0 +1,184 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ScheduleUserErasure schedules the user to be erased once the cooling off period has passed, returning when that will happen.
+// Scheduling again does not push back an erasure that is already scheduled.
+func ScheduleUserErasure(ctx context.Context, dbPool *pgxpool.Pool, userID int, coolingOff time.Duration) (time.Time, error) {
+	query := `
+		UPDATE users SET erasure_scheduled_at = COALESCE(erasure_scheduled_at, CURRENT_TIMESTAMP + $2::interval)
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING erasure_scheduled_at`
+
+	var scheduledAt time.Time
+	err := dbPool.QueryRow(ctx, query, userID, coolingOff).Scan(&scheduledAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrUserNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to schedule erasure for user %d: %w", userID, err)
+	}
+
+	slog.Info("User erasure scheduled", "id", userID, "scheduled_at", scheduledAt)
+
+	return scheduledAt, nil
+}
+
+// CancelUserErasure cancels a scheduled erasure, returning ErrUserNotFound if the user has no erasure scheduled
+func CancelUserErasure(ctx context.Context, dbPool *pgxpool.Pool, userID int) error {
+	query := `UPDATE users SET erasure_scheduled_at = NULL WHERE id = $1 AND erasure_scheduled_at IS NOT NULL`
+
+	ct, err := dbPool.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel erasure for user %d: %w", userID, err)
+	}
+
+	if ct.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	slog.Info("User erasure cancelled", "id", userID)
+
+	return nil
+}
+
+// EraseScheduledUsers erases every user whose cooling off period has ended. It returns how many users were erased
+// and the keys of their avatars, which are left for the caller to delete.
+func EraseScheduledUsers(ctx context.Context, dbPool *pgxpool.Pool) (int, []string, error) {
+	rows, err := dbPool.Query(ctx, `SELECT id FROM users WHERE erasure_scheduled_at <= CURRENT_TIMESTAMP ORDER BY id`)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to retrieve users scheduled for erasure: %w", err)
+	}
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to collect users scheduled for erasure: %w", err)
+	}
+
+	erased := 0
+	var avatarKeys []string
+	for _, id := range ids {
+		ok, avatarKey, err := eraseUser(ctx, dbPool, id)
+		if err != nil {
+			return erased, avatarKeys, err
+		}
+		if ok {
+			erased++
+		}
+		if avatarKey != nil {
+			avatarKeys = append(avatarKeys, *avatarKey)
+		}
+	}
+
+	return erased, avatarKeys, nil
+}
+
+// eraseUser deletes the user and anonymizes the rows that must keep referring to them, such as audit events, including
+// those recorded under an email the user had before changing it and those about the user's email, like invitations. Personal data is also removed from the user's outbox
+// events, and jobs that mention any of the user's emails, such as emails to them, are deleted unless they are running.
+// It returns false if the erasure was cancelled in the meantime, and the key of the user's avatar if they had one.
+func eraseUser(ctx context.Context, dbPool *pgxpool.Pool, id int) (bool, *string, error) {
+	erased := false
+	var avatarKey *string
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var email string
+		err := tx.QueryRow(ctx, `SELECT email, avatar_key FROM users WHERE id = $1 AND erasure_scheduled_at <= CURRENT_TIMESTAMP FOR UPDATE`, id).Scan(&email, &avatarKey)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock user %d for erasure: %w", id, err)
+		}
+
+		pseudonym := fmt.Sprintf("erased-user-%d", id)
+		target := fmt.Sprintf("user:%d", id)
+
+		// Emails the user changed away from, unless someone else has taken them since, in which case their events and
+		// jobs can't be told apart from the new owner's
+		rows, err := tx.Query(ctx, `
+			SELECT DISTINCT before->>'email' FROM audit_events e
+			WHERE action = 'users.change_email' AND target = $2 AND before ? 'email'
+				AND NOT EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(e.before->>'email') AND u.id <> $1)`, id, target)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve previous emails of user %d: %w", id, err)
+		}
+		emails, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("failed to collect previous emails of user %d: %w", id, err)
+		}
+		emails = append(emails, email)
+
+		// Audit events are append only to the application, so they are anonymized by a database function that runs as
+		// the role that owns the table
+		_, err = tx.Exec(ctx, `SELECT anonymize_erased_user_audit_events($1, $2)`, id, emails)
+		if err != nil {
+			return fmt.Errorf("failed to anonymize audit events for user %d: %w", id, err)
+		}
+
+		query := `
+			UPDATE outbox_events SET payload = (payload - 'email' - 'first_name' - 'last_name' - 'avatar_url' - 'attributes')
+				|| CASE WHEN jsonb_typeof(payload->'changes') = 'object'
+					THEN jsonb_build_object('changes', payload->'changes' - 'email' - 'first_name' - 'last_name' - 'avatar_url' - 'attributes')
+					ELSE '{}'::jsonb END
+			WHERE aggregate_type = 'user' AND aggregate_id = $1`
+		_, err = tx.Exec(ctx, query, strconv.Itoa(id))
+		if err != nil {
+			return fmt.Errorf("failed to anonymize outbox events for user %d: %w", id, err)
+		}
+
+		// Addresses the user asked to change to are included, as they are mentioned by the emails confirming them
+		query = `
+			DELETE FROM jobs j
+			WHERE state <> 'running' AND EXISTS (
+				SELECT 1 FROM (
+					SELECT unnest($1::text[]) AS email
+					UNION SELECT new_email FROM email_change_requests r WHERE user_id = $2 AND confirmed_at IS NULL
+						AND NOT EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(r.new_email) AND u.id <> $2)
+				) e
+				WHERE strpos(lower(j.args::text), lower(e.email)) > 0
+			)`
+		_, err = tx.Exec(ctx, query, emails, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete jobs mentioning user %d: %w", id, err)
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+		if err != nil {
+			return fmt.Errorf("failed to erase user %d: %w", id, err)
+		}
+
+		if err = insertUserEvent(ctx, tx, id, UserDeletedEvent, map[string]any{"id": id, "permanent": true}); err != nil {
+			return err
+		}
+
+		err = InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &pseudonym,
+			Action:     "users.erase",
+			Target:     &target,
+		})
+		if err != nil {
+			return err
+		}
+
+		erased = true
+		return nil
+	})
+	if err != nil || !erased {
+		return false, nil, err
+	}
+
+	slog.Info("User erased", "id", id)
+
+	return true, avatarKey, nil
+}
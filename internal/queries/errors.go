@@ -0,0 +1,92 @@
+package queries
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// ErrNotFound is matched by every error returned when a query targets a row that does not exist, such as ErrUserNotFound
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is matched by every error returned when a change clashes with existing data, such as ErrEmailTaken
+	ErrConflict = errors.New("conflict")
+	// ErrForbidden is matched by every error returned when the caller may not make a change, such as ErrInvitationEmailMismatch
+	ErrForbidden = errors.New("forbidden")
+)
+
+// kindError is a specific sentinel error, like ErrUserNotFound, that also matches the generic kind it belongs to,
+// so callers can check for either
+type kindError struct {
+	message string
+	kind    error
+}
+
+func newKindError(kind error, message string) error {
+	return &kindError{message: message, kind: kind}
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
+
+// constraintFields maps the constraints whose names don't say which field they check to that field
+var constraintFields = map[string]string{
+	"email_unique":              "email",
+	"organizations_slug_unique": "slug",
+}
+
+// ErrConstraint is returned when a write violates a database constraint, and names the field that was rejected when it
+// is known. Unique violations also match ErrConflict, while the others are invalid values the database refused.
+type ErrConstraint struct {
+	Field      string
+	Constraint string
+	err        *pgconn.PgError
+}
+
+func (e *ErrConstraint) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("constraint violation: %s", e.err.Message)
+	}
+	return fmt.Sprintf("constraint violation on %s: %s", e.Field, e.err.Message)
+}
+
+func (e *ErrConstraint) Unwrap() []error {
+	if e.err.Code == "23505" {
+		return []error{ErrConflict, e.err}
+	}
+	return []error{e.err}
+}
+
+// MapConstraintError returns an ErrConstraint for errors caused by integrity constraint violations or values too long
+// for their column, and any other error unchanged. WithTx maps the errors of every transaction with it.
+func MapConstraintError(err error) error {
+	var constraintErr *ErrConstraint
+	if errors.As(err, &constraintErr) {
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || (!strings.HasPrefix(pgErr.Code, "23") && pgErr.Code != "22001") {
+		return err
+	}
+
+	field := pgErr.ColumnName
+	if f, ok := constraintFields[pgErr.ConstraintName]; ok {
+		field = f
+	} else if field == "" && pgErr.ConstraintName != "" {
+		// Postgres names constraints it generates <table>_<column>_<kind>, like memberships_role_check
+		field = strings.TrimPrefix(pgErr.ConstraintName, pgErr.TableName+"_")
+		for _, suffix := range []string{"_check", "_key", "_fkey"} {
+			field = strings.TrimSuffix(field, suffix)
+		}
+	}
+
+	return &ErrConstraint{Field: field, Constraint: pgErr.ConstraintName, err: pgErr}
+}
@@ -0,0 +1,265 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrInvitationNotFound is returned when an invitation token is unknown, expired or already used
+	ErrInvitationNotFound = newKindError(ErrNotFound, "invitation not found")
+	// ErrInvitationEmailMismatch is returned when an invitation is accepted by a user with a different email
+	ErrInvitationEmailMismatch = newKindError(ErrForbidden, "invitation was sent to a different email")
+	// ErrAlreadyMember is returned when inviting someone who is already a member of the organization
+	ErrAlreadyMember = newKindError(ErrConflict, "user is already a member of the organization")
+)
+
+const invitationColumns = `id, organization_id, email, role, invited_by, created_at, expires_at, accepted_at, accepted_by`
+
+// CreateInvitation invites the email to the scoped organization, replacing any earlier pending invitation for it, and
+// queues the job that emails the invite link in the same transaction
+func CreateInvitation(ctx context.Context, dbPool *pgxpool.Pool, scope OrgScope, email, role string, invitedBy models.User, ttl time.Duration) (models.Invitation, error) {
+	var invitation models.Invitation
+	err := scope.WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		rows, err := scope.Query(ctx, tx, `
+			SELECT EXISTS (
+				SELECT 1 FROM memberships m JOIN users u ON u.id = m.user_id
+				WHERE m.organization_id = @organization_id AND lower(u.email) = lower(@email) AND u.deleted_at IS NULL
+			)`, pgx.NamedArgs{"email": email})
+		if err != nil {
+			return fmt.Errorf("failed to check membership of %q: %w", email, err)
+		}
+
+		isMember, err := pgx.CollectExactlyOneRow(rows, pgx.RowTo[bool])
+		if err != nil {
+			return fmt.Errorf("failed to check membership of %q: %w", email, err)
+		}
+		if isMember {
+			return ErrAlreadyMember
+		}
+
+		_, err = scope.Exec(ctx, tx, `DELETE FROM invitations WHERE organization_id = @organization_id AND lower(email) = lower(@email) AND accepted_at IS NULL`, pgx.NamedArgs{"email": email})
+		if err != nil {
+			return fmt.Errorf("failed to remove previous invitations for %q: %w", email, err)
+		}
+
+		query := `
+			INSERT INTO invitations (organization_id, email, role, invited_by, expires_at)
+			VALUES (@organization_id, @email, @role, @invited_by, CURRENT_TIMESTAMP + @ttl::interval)
+			RETURNING ` + invitationColumns
+
+		rows, err = scope.Query(ctx, tx, query, pgx.NamedArgs{
+			"email":      email,
+			"role":       role,
+			"invited_by": invitedBy.ID,
+			"ttl":        ttl,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create invitation: %w", err)
+		}
+
+		invitation, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Invitation])
+		if err != nil {
+			return fmt.Errorf("failed to create invitation: %w", err)
+		}
+
+		_, err = InsertJob(ctx, tx, models.InvitationEmailJob{InvitationID: invitation.ID}, JobOptions{UniqueKey: strconv.FormatInt(invitation.ID, 10)})
+		if err != nil {
+			return err
+		}
+
+		target := fmt.Sprintf("organization:%d", scope.OrganizationID)
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &invitedBy.Email,
+			Action:     "organizations.invite",
+			Target:     &target,
+			Details: map[string]any{
+				"invitation_id": invitation.ID,
+				"email":         email,
+				"role":          role,
+			},
+		})
+	})
+	if err != nil {
+		return models.Invitation{}, err
+	}
+
+	return invitation, nil
+}
+
+// IssueInvitationToken sets the hash of the token in a pending invitation's invite link, replacing any token issued for
+// it before, and returns what the invitation email says. It returns ErrInvitationNotFound once the invitation is
+// accepted, expired or replaced.
+func IssueInvitationToken(ctx context.Context, dbPool *pgxpool.Pool, id int64, tokenHash string) (models.InvitationEmail, error) {
+	query := `
+		WITH issued AS (
+			UPDATE invitations SET token_hash = $2
+			WHERE id = $1 AND accepted_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+			RETURNING email, role, organization_id, invited_by, expires_at
+		)
+		SELECT i.email, i.role, o.name AS organization_name, u.email AS invited_by_email, i.expires_at
+		FROM issued i
+		JOIN organizations o ON o.id = i.organization_id
+		LEFT JOIN users u ON u.id = i.invited_by AND u.deleted_at IS NULL`
+
+	rows, err := dbPool.Query(ctx, query, id, tokenHash)
+	if err != nil {
+		return models.InvitationEmail{}, fmt.Errorf("failed to issue token for invitation %d: %w", id, err)
+	}
+
+	invitation, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.InvitationEmail])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.InvitationEmail{}, ErrInvitationNotFound
+	}
+	if err != nil {
+		return models.InvitationEmail{}, fmt.Errorf("failed to collect invitation %d: %w", id, err)
+	}
+
+	return invitation, nil
+}
+
+// GetInvitationDetails returns a pending invitation by the hash of its token, or ErrInvitationNotFound.
+// A deleted account with the invited email doesn't count as an existing user, as it can't log in to accept the invitation,
+// so the invitee signs up for a new account instead, after which the deleted one can no longer be restored.
+func GetInvitationDetails(ctx context.Context, dbPool *pgxpool.Pool, tokenHash string) (models.InvitationDetails, error) {
+	query := `
+		SELECT i.email, i.role, o.name AS organization_name, o.slug AS organization_slug, i.expires_at,
+			EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(i.email) AND u.deleted_at IS NULL) AS existing_user
+		FROM invitations i JOIN organizations o ON o.id = i.organization_id
+		WHERE i.token_hash = $1 AND i.accepted_at IS NULL AND i.expires_at > CURRENT_TIMESTAMP`
+
+	rows, err := dbPool.Query(ctx, query, tokenHash)
+	if err != nil {
+		return models.InvitationDetails{}, fmt.Errorf("failed to retrieve invitation: %w", err)
+	}
+
+	details, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.InvitationDetails])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.InvitationDetails{}, ErrInvitationNotFound
+	}
+	if err != nil {
+		return models.InvitationDetails{}, fmt.Errorf("failed to collect invitation: %w", err)
+	}
+
+	return details, nil
+}
+
+// AcceptInvitation adds an existing user to the invitation's organization and uses up the invitation.
+// The user's email must be the one the invitation was sent to.
+func AcceptInvitation(ctx context.Context, dbPool *pgxpool.Pool, tokenHash string, user models.User) (models.Membership, error) {
+	var membership models.Membership
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		membership, err = acceptInvitation(ctx, tx, tokenHash, user.ID, user.Email)
+		return err
+	})
+	if err != nil {
+		return models.Membership{}, err
+	}
+
+	return membership, nil
+}
+
+// SignUpInvitedUser creates the user and accepts the invitation in the same transaction, so the invitee
+// never ends up with an account outside of the organization they were invited to.
+// It returns the new user's id, or ErrEmailTaken if an account with the email was created in the meantime.
+func SignUpInvitedUser(ctx context.Context, dbPool *pgxpool.Pool, tokenHash string, user models.User) (int, error) {
+	var id int
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		id, err = InsertUser(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+
+		_, err = acceptInvitation(ctx, tx, tokenHash, id, user.Email)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slog.Info(fmt.Sprintf("Invited user signed up successfully: %s", user.Email), "id", id)
+
+	return id, nil
+}
+
+func acceptInvitation(ctx context.Context, tx pgx.Tx, tokenHash string, userID int, email string) (models.Membership, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE`
+
+	rows, err := tx.Query(ctx, query, tokenHash)
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to retrieve invitation: %w", err)
+	}
+
+	invitation, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Invitation])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Membership{}, ErrInvitationNotFound
+	}
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to collect invitation: %w", err)
+	}
+
+	if !strings.EqualFold(invitation.Email, email) {
+		return models.Membership{}, ErrInvitationEmailMismatch
+	}
+
+	// Accepting an invitation never lowers the role of someone who joined the organization some other way in the meantime
+	membershipQuery := `
+		INSERT INTO memberships (organization_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (organization_id, user_id) DO UPDATE SET role = memberships.role
+		RETURNING organization_id, user_id, role, created_at`
+
+	rows, err = tx.Query(ctx, membershipQuery, invitation.OrganizationID, userID, invitation.Role)
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to add user %d to organization %d: %w", userID, invitation.OrganizationID, err)
+	}
+
+	membership, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Membership])
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to add user %d to organization %d: %w", userID, invitation.OrganizationID, err)
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE invitations SET accepted_at = CURRENT_TIMESTAMP, accepted_by = $2 WHERE id = $1`, invitation.ID, userID)
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to mark invitation %d accepted: %w", invitation.ID, err)
+	}
+
+	target := fmt.Sprintf("organization:%d", invitation.OrganizationID)
+	err = InsertAuditEvent(ctx, tx, models.AuditEvent{
+		ActorEmail: &email,
+		Action:     "organizations.accept_invitation",
+		Target:     &target,
+		Details: map[string]any{
+			"invitation_id": invitation.ID,
+			"user_id":       userID,
+			"role":          membership.Role,
+		},
+	})
+	if err != nil {
+		return models.Membership{}, err
+	}
+
+	return membership, nil
+}
+
+// PurgeExpiredInvitations removes invitations that expired without being accepted
+func PurgeExpiredInvitations(ctx context.Context, dbPool *pgxpool.Pool) (int64, error) {
+	ct, err := dbPool.Exec(ctx, `DELETE FROM invitations WHERE accepted_at IS NULL AND expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired invitations: %w", err)
+	}
+
+	return ct.RowsAffected(), nil
+}
@@ -0,0 +1,181 @@
+package queries
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const defaultJobMaxAttempts = 5
+
+// JobOptions configures how a job is queued, the zero value runs it as soon as possible with 5 attempts
+type JobOptions struct {
+	// RunAt schedules the job to run no earlier than the time
+	RunAt       time.Time
+	MaxAttempts int
+	// UniqueKey stops the job being queued while another job of the same kind with the same key is waiting or running
+	UniqueKey string
+}
+
+// InsertJob queues a job inside the given transaction, so it only runs if the transaction commits.
+// It returns false without an error when a unique job with the same key is already queued.
+func InsertJob(ctx context.Context, tx pgx.Tx, args models.JobArgs, opts JobOptions) (bool, error) {
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode arguments of %s job: %w", args.Kind(), err)
+	}
+
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = defaultJobMaxAttempts
+	}
+
+	namedArgs := pgx.NamedArgs{
+		"kind":         args.Kind(),
+		"args":         string(encodedArgs),
+		"max_attempts": maxAttempts,
+		"run_at":       nil,
+		"unique_key":   nil,
+	}
+	if !opts.RunAt.IsZero() {
+		namedArgs["run_at"] = opts.RunAt
+	}
+	if opts.UniqueKey != "" {
+		namedArgs["unique_key"] = opts.UniqueKey
+	}
+
+	query := `
+		INSERT INTO jobs (kind, args, max_attempts, run_at, unique_key)
+		VALUES (@kind, @args::jsonb, @max_attempts, COALESCE(@run_at::timestamptz, CURRENT_TIMESTAMP), @unique_key)
+		ON CONFLICT (kind, unique_key) WHERE unique_key IS NOT NULL AND state IN ('pending', 'running') DO NOTHING`
+
+	ct, err := tx.Exec(ctx, query, namedArgs)
+	if err != nil {
+		return false, fmt.Errorf("failed to queue %s job: %w", args.Kind(), err)
+	}
+
+	return ct.RowsAffected() == 1, nil
+}
+
+// EnqueueJob queues a job in its own transaction, see InsertJob
+func EnqueueJob(ctx context.Context, dbPool *pgxpool.Pool, args models.JobArgs, opts JobOptions) (bool, error) {
+	var inserted bool
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var err error
+		inserted, err = InsertJob(ctx, tx, args, opts)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return inserted, nil
+}
+
+// ClaimJobs marks up to limit due jobs of the kinds as running and returns them, oldest first. Jobs left running for
+// longer than staleAfter, e.g. by a crashed instance, are claimed again if they have attempts left, and marked dead
+// otherwise. Each claim counts as an attempt.
+func ClaimJobs(ctx context.Context, dbPool *pgxpool.Pool, kinds []string, limit int, staleAfter time.Duration) ([]models.Job, error) {
+	var jobs []models.Job
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		deadQuery := `
+			UPDATE jobs SET state = 'dead', locked_at = NULL,
+				last_error = 'job was still running when its worker stopped, and has no attempts left'
+			WHERE id IN (
+				SELECT id FROM jobs
+				WHERE kind = ANY($1) AND state = 'running' AND locked_at < CURRENT_TIMESTAMP - $2::interval
+					AND attempts >= max_attempts
+				FOR UPDATE SKIP LOCKED
+			)`
+
+		ct, err := tx.Exec(ctx, deadQuery, kinds, staleAfter)
+		if err != nil {
+			return fmt.Errorf("failed to mark stale jobs as dead: %w", err)
+		}
+		if ct.RowsAffected() > 0 {
+			slog.Warn("Stale jobs with no attempts left marked as dead", "count", ct.RowsAffected())
+		}
+
+		query := `
+			UPDATE jobs SET state = 'running', attempts = attempts + 1, locked_at = CURRENT_TIMESTAMP
+			WHERE id IN (
+				SELECT id FROM jobs
+				WHERE kind = ANY($1) AND (
+					(state = 'pending' AND run_at <= CURRENT_TIMESTAMP)
+					OR (state = 'running' AND locked_at < CURRENT_TIMESTAMP - $3::interval AND attempts < max_attempts)
+				)
+				ORDER BY run_at, id
+				FOR UPDATE SKIP LOCKED
+				LIMIT $2
+			)
+			RETURNING id, kind, args, attempts, max_attempts, run_at`
+
+		rows, err := tx.Query(ctx, query, kinds, limit, staleAfter)
+		if err != nil {
+			return fmt.Errorf("failed to claim jobs: %w", err)
+		}
+
+		jobs, err = pgx.CollectRows(rows, pgx.RowToStructByName[models.Job])
+		if err != nil {
+			return fmt.Errorf("failed to collect claimed jobs: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// CompleteJob removes a job that finished successfully
+func CompleteJob(ctx context.Context, dbPool *pgxpool.Pool, id int64) error {
+	_, err := dbPool.Exec(ctx, `DELETE FROM jobs WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete job %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// RetryJob puts a failed job back in the queue to run again after the delay
+func RetryJob(ctx context.Context, dbPool *pgxpool.Pool, id int64, reason string, delay time.Duration) error {
+	query := `
+		UPDATE jobs SET state = 'pending', last_error = $2, locked_at = NULL, run_at = CURRENT_TIMESTAMP + $3::interval
+		WHERE id = $1`
+
+	_, err := dbPool.Exec(ctx, query, id, reason, delay)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule job %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// DeadLetterJob marks a job that won't be retried as dead, keeping it and its last error for inspection
+func DeadLetterJob(ctx context.Context, dbPool *pgxpool.Pool, id int64, reason string) error {
+	_, err := dbPool.Exec(ctx, `UPDATE jobs SET state = 'dead', last_error = $2, locked_at = NULL WHERE id = $1`, id, reason)
+	if err != nil {
+		return fmt.Errorf("failed to mark job %d as dead: %w", id, err)
+	}
+
+	return nil
+}
+
+// PurgeDeadJobs removes dead jobs whose last attempt was due longer ago than the retention period. Their arguments can
+// hold personal data, such as the address an email was for, so they are only kept long enough to look into.
+func PurgeDeadJobs(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) (int64, error) {
+	ct, err := dbPool.Exec(ctx, `DELETE FROM jobs WHERE state = 'dead' AND run_at < CURRENT_TIMESTAMP - $1::interval`, retention)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead jobs: %w", err)
+	}
+
+	return ct.RowsAffected(), nil
+}
@@ -0,0 +1,358 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	// ErrOrganizationNotFound is returned when an organization does not exist
+	ErrOrganizationNotFound = newKindError(ErrNotFound, "organization not found")
+	// ErrMembershipNotFound is returned when a user is not a member of an organization
+	ErrMembershipNotFound = newKindError(ErrNotFound, "membership not found")
+	// ErrSlugTaken is returned when another organization already has the slug
+	ErrSlugTaken = newKindError(ErrConflict, "organization slug already taken")
+	// ErrLastOwner is returned when a change would leave an organization without an owner
+	ErrLastOwner = newKindError(ErrConflict, "organization must keep at least one owner")
+)
+
+// tenantRole is the database role organization scoped transactions run as, which the row level security policies on
+// tenant tables apply to. Its policies read the organization and user from the app.current_org_id and
+// app.current_user_id settings, so a query that forgets to filter on the organization still only sees its own rows.
+const tenantRole = "app_tenant"
+
+// OrgScope restricts queries to a single organization, the caller's active one, on behalf of one of its members.
+// Its Query and Exec methods refuse queries that don't filter on @organization_id, and always bind it themselves,
+// so a handler cannot read or write another organization's rows by passing a different id.
+type OrgScope struct {
+	OrganizationID int
+	UserID         int
+}
+
+// ScopeForMembership returns the scope of a member acting within their organization
+func ScopeForMembership(membership models.Membership) OrgScope {
+	return OrgScope{OrganizationID: membership.OrganizationID, UserID: membership.UserID}
+}
+
+// WithTx runs fn in a transaction like the package level WithTx, as the tenant role with the scope's organization and
+// user set, so row level security limits every statement in it to the organization. Both only last until the
+// transaction ends, so they never leak into other requests sharing the pooled connection.
+func (s OrgScope) WithTx(ctx context.Context, dbPool *pgxpool.Pool, opts TxOptions, fn func(tx pgx.Tx) error) error {
+	if s.OrganizationID == 0 {
+		return fmt.Errorf("scoped transaction started without an organization")
+	}
+
+	return WithTx(ctx, dbPool, opts, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, "SET LOCAL ROLE "+tenantRole)
+		if err == nil {
+			_, err = tx.Exec(ctx, `SELECT set_config('app.current_org_id', $1, true), set_config('app.current_user_id', $2, true)`,
+				strconv.Itoa(s.OrganizationID), strconv.Itoa(s.UserID))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to scope transaction to organization %d: %w", s.OrganizationID, err)
+		}
+
+		return fn(tx)
+	})
+}
+
+// Query runs a read scoped to the organization, in a transaction run by WithTx
+func (s OrgScope) Query(ctx context.Context, tx pgx.Tx, query string, args pgx.NamedArgs) (pgx.Rows, error) {
+	scopedArgs, err := s.args(query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.Query(ctx, query, scopedArgs)
+}
+
+// Exec runs a write scoped to the organization, in a transaction run by WithTx
+func (s OrgScope) Exec(ctx context.Context, tx pgx.Tx, query string, args pgx.NamedArgs) (pgconn.CommandTag, error) {
+	scopedArgs, err := s.args(query, args)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	return tx.Exec(ctx, query, scopedArgs)
+}
+
+func (s OrgScope) args(query string, args pgx.NamedArgs) (pgx.NamedArgs, error) {
+	if s.OrganizationID == 0 {
+		return nil, fmt.Errorf("scoped query run without an organization")
+	}
+	if !strings.Contains(query, "@organization_id") {
+		return nil, fmt.Errorf("scoped query does not filter on @organization_id: %s", query)
+	}
+	if _, ok := args["organization_id"]; ok {
+		return nil, fmt.Errorf("scoped query must not set organization_id itself")
+	}
+
+	scopedArgs := pgx.NamedArgs{"organization_id": s.OrganizationID}
+	for name, value := range args {
+		scopedArgs[name] = value
+	}
+
+	return scopedArgs, nil
+}
+
+// CreateOrganization creates an organization with the user as its owner
+func CreateOrganization(ctx context.Context, dbPool *pgxpool.Pool, name, slug string, owner models.User) (models.Organization, error) {
+	var organization models.Organization
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `INSERT INTO organizations (name, slug) VALUES ($1, $2) RETURNING id, name, slug, created_at`, name, slug)
+		if err != nil {
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+
+		organization, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Organization])
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrSlugTaken
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `INSERT INTO memberships (organization_id, user_id, role) VALUES ($1, $2, $3)`, organization.ID, owner.ID, models.OrgRoleOwner)
+		if err != nil {
+			return fmt.Errorf("failed to add owner to organization %d: %w", organization.ID, err)
+		}
+
+		target := fmt.Sprintf("organization:%d", organization.ID)
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &owner.Email,
+			Action:     "organizations.create",
+			Target:     &target,
+			Details: map[string]any{
+				"name": name,
+				"slug": slug,
+			},
+		})
+	})
+	if err != nil {
+		return models.Organization{}, err
+	}
+
+	slog.Info("Organization created", "id", organization.ID, "slug", slug, "owner", owner.Email)
+
+	return organization, nil
+}
+
+// UpsertOrganization creates the organization with the slug, or renames it if it already exists, and gives each user
+// in members the role it maps to, adding them to the organization if needed. Members missing from the map are kept.
+// It is meant for loading fixtures, so unlike CreateOrganization it records no audit events.
+func UpsertOrganization(ctx context.Context, dbPool *pgxpool.Pool, name, slug string, members map[int]string) (models.Organization, error) {
+	var organization models.Organization
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		query := `
+			INSERT INTO organizations (name, slug) VALUES ($1, $2)
+			ON CONFLICT (slug) DO UPDATE SET name = EXCLUDED.name
+			RETURNING id, name, slug, created_at`
+
+		rows, err := tx.Query(ctx, query, name, slug)
+		if err != nil {
+			return fmt.Errorf("failed to upsert organization %q: %w", slug, err)
+		}
+
+		organization, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Organization])
+		if err != nil {
+			return fmt.Errorf("failed to collect organization %q: %w", slug, err)
+		}
+
+		for userID, role := range members {
+			_, err = tx.Exec(ctx, `
+				INSERT INTO memberships (organization_id, user_id, role) VALUES ($1, $2, $3)
+				ON CONFLICT (organization_id, user_id) DO UPDATE SET role = EXCLUDED.role`, organization.ID, userID, role)
+			if err != nil {
+				return fmt.Errorf("failed to add user %d to organization %d: %w", userID, organization.ID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.Organization{}, err
+	}
+
+	return organization, nil
+}
+
+// GetOrganizationsForUser lists the organizations the user is a member of, with their role in each
+func GetOrganizationsForUser(ctx context.Context, dbPool *pgxpool.Pool, userID int) ([]models.UserOrganization, error) {
+	query := `
+		SELECT o.id, o.name, o.slug, o.created_at, m.role, m.created_at AS joined_at
+		FROM memberships m JOIN organizations o ON o.id = m.organization_id
+		WHERE m.user_id = $1
+		ORDER BY m.created_at, o.id`
+
+	rows, err := dbPool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve organizations for user %d: %w", userID, err)
+	}
+
+	organizations, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.UserOrganization])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect organizations for user %d: %w", userID, err)
+	}
+
+	return organizations, nil
+}
+
+// GetMembership returns the user's membership of the organization, or ErrMembershipNotFound
+func GetMembership(ctx context.Context, dbPool *pgxpool.Pool, organizationID, userID int) (models.Membership, error) {
+	query := `SELECT organization_id, user_id, role, created_at FROM memberships WHERE organization_id = $1 AND user_id = $2`
+
+	rows, err := dbPool.Query(ctx, query, organizationID, userID)
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to retrieve membership of user %d in organization %d: %w", userID, organizationID, err)
+	}
+
+	membership, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Membership])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Membership{}, ErrMembershipNotFound
+	}
+	if err != nil {
+		return models.Membership{}, fmt.Errorf("failed to collect membership of user %d in organization %d: %w", userID, organizationID, err)
+	}
+
+	return membership, nil
+}
+
+// GetOrganization returns the organization the scope is limited to
+func GetOrganization(ctx context.Context, dbPool *pgxpool.Pool, scope OrgScope) (models.Organization, error) {
+	var organization models.Organization
+	err := scope.WithTx(ctx, dbPool, TxOptions{ReadOnly: true}, func(tx pgx.Tx) error {
+		rows, err := scope.Query(ctx, tx, `SELECT id, name, slug, created_at FROM organizations WHERE id = @organization_id`, nil)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve organization %d: %w", scope.OrganizationID, err)
+		}
+
+		organization, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByName[models.Organization])
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrganizationNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to collect organization %d: %w", scope.OrganizationID, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.Organization{}, err
+	}
+
+	return organization, nil
+}
+
+// GetOrganizationMembers lists the members of the organization the scope is limited to
+func GetOrganizationMembers(ctx context.Context, dbPool *pgxpool.Pool, scope OrgScope) ([]models.OrganizationMember, error) {
+	query := `
+		SELECT u.id AS user_id, u.email, u.first_name, u.last_name, m.role, m.created_at AS joined_at
+		FROM memberships m JOIN users u ON u.id = m.user_id
+		WHERE m.organization_id = @organization_id AND u.deleted_at IS NULL
+		ORDER BY m.created_at, u.id`
+
+	var members []models.OrganizationMember
+	err := scope.WithTx(ctx, dbPool, TxOptions{ReadOnly: true}, func(tx pgx.Tx) error {
+		rows, err := scope.Query(ctx, tx, query, nil)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve members of organization %d: %w", scope.OrganizationID, err)
+		}
+
+		members, err = pgx.CollectRows(rows, pgx.RowToStructByName[models.OrganizationMember])
+		if err != nil {
+			return fmt.Errorf("failed to collect members of organization %d: %w", scope.OrganizationID, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// UpdateMemberRole changes a member's role in the scoped organization. It returns ErrMembershipNotFound if the
+// user is not a member, and ErrLastOwner if the change would leave the organization without an owner.
+func UpdateMemberRole(ctx context.Context, dbPool *pgxpool.Pool, scope OrgScope, userID int, role string, actorEmail string) error {
+	return changeMembership(ctx, dbPool, scope, userID, &role, actorEmail)
+}
+
+// RemoveMember removes a user from the scoped organization, with the same checks as UpdateMemberRole
+func RemoveMember(ctx context.Context, dbPool *pgxpool.Pool, scope OrgScope, userID int, actorEmail string) error {
+	return changeMembership(ctx, dbPool, scope, userID, nil, actorEmail)
+}
+
+// changeMembership updates the member's role, or removes them when role is nil
+func changeMembership(ctx context.Context, dbPool *pgxpool.Pool, scope OrgScope, userID int, role *string, actorEmail string) error {
+	return scope.WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		return changeMembershipTx(ctx, tx, scope, userID, role, actorEmail)
+	})
+}
+
+func changeMembershipTx(ctx context.Context, tx pgx.Tx, scope OrgScope, userID int, role *string, actorEmail string) error {
+	// Locking every owner row serializes concurrent changes that could each remove one of the last two owners
+	rows, err := scope.Query(ctx, tx, `SELECT user_id, role FROM memberships WHERE organization_id = @organization_id AND (role = 'owner' OR user_id = @user_id) FOR UPDATE`, pgx.NamedArgs{"user_id": userID})
+	if err != nil {
+		return fmt.Errorf("failed to lock memberships of organization %d: %w", scope.OrganizationID, err)
+	}
+
+	owners := 0
+	currentRole := ""
+	var memberUserID int
+	var memberRole string
+	_, err = pgx.ForEachRow(rows, []any{&memberUserID, &memberRole}, func() error {
+		if memberRole == models.OrgRoleOwner {
+			owners++
+		}
+		if memberUserID == userID {
+			currentRole = memberRole
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to collect memberships of organization %d: %w", scope.OrganizationID, err)
+	}
+
+	if currentRole == "" {
+		return ErrMembershipNotFound
+	}
+	if currentRole == models.OrgRoleOwner && owners == 1 && (role == nil || *role != models.OrgRoleOwner) {
+		return ErrLastOwner
+	}
+
+	action := "organizations.remove_member"
+	details := map[string]any{"user_id": userID}
+	before := map[string]any{"role": currentRole}
+	var after map[string]any
+	if role != nil {
+		action = "organizations.update_member"
+		after = map[string]any{"role": *role}
+		_, err = scope.Exec(ctx, tx, `UPDATE memberships SET role = @role WHERE organization_id = @organization_id AND user_id = @user_id`, pgx.NamedArgs{"user_id": userID, "role": *role})
+	} else {
+		_, err = scope.Exec(ctx, tx, `DELETE FROM memberships WHERE organization_id = @organization_id AND user_id = @user_id`, pgx.NamedArgs{"user_id": userID})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to change membership of user %d in organization %d: %w", userID, scope.OrganizationID, err)
+	}
+
+	target := fmt.Sprintf("organization:%d", scope.OrganizationID)
+	return InsertAuditEvent(ctx, tx, models.AuditEvent{
+		ActorEmail: &actorEmail,
+		Action:     action,
+		Target:     &target,
+		Details:    details,
+		Before:     before,
+		After:      after,
+	})
+}
@@ -0,0 +1,140 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// outboxRelayLockID is the transaction level advisory lock held while relaying outbox events, so only one instance
+// delivers them at a time and events about the same aggregate can't overtake each other
+const outboxRelayLockID = 7351208414620032001
+
+// Outbox event types published for users, whose aggregate id is the user's id
+const (
+	UserCreatedEvent  = "users.created"
+	UserUpdatedEvent  = "users.updated"
+	UserDeletedEvent  = "users.deleted"
+	UserRestoredEvent = "users.restored"
+)
+
+// InsertOutboxEvent writes an event to the outbox inside the given transaction, so it is only delivered if the change
+// it describes commits
+func InsertOutboxEvent(ctx context.Context, tx pgx.Tx, event models.OutboxEvent) error {
+	if event.Payload == nil {
+		event.Payload = map[string]any{}
+	}
+
+	query := `INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload) VALUES ($1, $2, $3, $4)`
+
+	_, err := tx.Exec(ctx, query, event.AggregateType, event.AggregateID, event.EventType, event.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to insert outbox event %q: %w", event.EventType, err)
+	}
+
+	return nil
+}
+
+// insertUserEvent writes an outbox event about the user with the id
+func insertUserEvent(ctx context.Context, tx pgx.Tx, id int, eventType string, payload map[string]any) error {
+	return InsertOutboxEvent(ctx, tx, models.OutboxEvent{
+		AggregateType: "user",
+		AggregateID:   strconv.Itoa(id),
+		EventType:     eventType,
+		Payload:       payload,
+	})
+}
+
+// RelayOutboxEvents passes up to limit pending events to deliver in the order they were written, marking those it
+// accepts as published and scheduling the others to be retried after retryDelay. Once an event about an aggregate
+// fails, later events about the same aggregate wait until it has been delivered. Events are marked when the batch
+// commits, so an event can be delivered again if that fails, but never lost. It returns how many events were
+// published and how many failed, and does nothing while another instance is relaying.
+func RelayOutboxEvents(ctx context.Context, dbPool *pgxpool.Pool, limit int, deliver func(context.Context, models.OutboxEvent) error, retryDelay func(attempts int) time.Duration) (int, int, error) {
+	var published, failed int
+	// Deliveries can't be undone, so a failed batch is left for the next run rather than retried straight away
+	err := WithTx(ctx, dbPool, TxOptions{MaxAttempts: 1}, func(tx pgx.Tx) error {
+		published, failed = 0, 0
+
+		var locked bool
+		if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, int64(outboxRelayLockID)).Scan(&locked); err != nil {
+			return fmt.Errorf("failed to lock outbox: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+
+		query := `
+			SELECT id, aggregate_type, aggregate_id, event_type, payload, created_at, attempts
+			FROM outbox_events e
+			WHERE published_at IS NULL AND NOT EXISTS (
+				SELECT 1 FROM outbox_events earlier
+				WHERE earlier.published_at IS NULL
+					AND earlier.aggregate_type = e.aggregate_type
+					AND earlier.aggregate_id = e.aggregate_id
+					AND earlier.id < e.id
+					AND earlier.next_attempt_at > CURRENT_TIMESTAMP
+			) AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY id
+			LIMIT $1`
+
+		rows, err := tx.Query(ctx, query, limit)
+		if err != nil {
+			return fmt.Errorf("failed to select pending outbox events: %w", err)
+		}
+
+		events, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.OutboxEvent])
+		if err != nil {
+			return fmt.Errorf("failed to collect pending outbox events: %w", err)
+		}
+
+		blocked := map[string]bool{}
+		for _, event := range events {
+			aggregate := event.AggregateType + ":" + event.AggregateID
+			if blocked[aggregate] {
+				continue
+			}
+
+			if deliverErr := deliver(ctx, event); deliverErr != nil {
+				blocked[aggregate] = true
+				failed++
+
+				_, err = tx.Exec(ctx, `
+					UPDATE outbox_events SET attempts = attempts + 1, last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + $3::interval
+					WHERE id = $1`, event.ID, deliverErr.Error(), retryDelay(event.Attempts+1))
+				if err != nil {
+					return fmt.Errorf("failed to reschedule outbox event %d: %w", event.ID, err)
+				}
+				continue
+			}
+
+			published++
+			_, err = tx.Exec(ctx, `UPDATE outbox_events SET published_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = NULL WHERE id = $1`, event.ID)
+			if err != nil {
+				return fmt.Errorf("failed to mark outbox event %d as published: %w", event.ID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return published, failed, nil
+}
+
+// PurgePublishedOutboxEvents removes events that were published longer ago than the retention period
+func PurgePublishedOutboxEvents(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) (int64, error) {
+	ct, err := dbPool.Exec(ctx, `DELETE FROM outbox_events WHERE published_at < CURRENT_TIMESTAMP - $1::interval`, retention)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge published outbox events: %w", err)
+	}
+
+	return ct.RowsAffected(), nil
+}
@@ -0,0 +1,77 @@
+package queries
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ClaimScheduledTaskRun records that the instance is starting the task's run scheduled for the time. It returns false
+// if that run, or a later one, has already been claimed.
+func ClaimScheduledTaskRun(ctx context.Context, dbPool *pgxpool.Pool, name string, scheduledFor time.Time, instance string) (bool, error) {
+	query := `
+		INSERT INTO scheduled_tasks (name, scheduled_for, status, instance, started_at)
+		VALUES ($1, $2, 'running', $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (name) DO UPDATE SET
+			scheduled_for = EXCLUDED.scheduled_for,
+			status = 'running',
+			error = NULL,
+			instance = EXCLUDED.instance,
+			started_at = EXCLUDED.started_at,
+			finished_at = NULL
+		WHERE scheduled_tasks.scheduled_for < EXCLUDED.scheduled_for`
+
+	ct, err := dbPool.Exec(ctx, query, name, scheduledFor, instance)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim run of scheduled task %s: %w", name, err)
+	}
+
+	return ct.RowsAffected() == 1, nil
+}
+
+// FinishScheduledTaskRun records the outcome of the task's run scheduled for the time, an empty reason means it succeeded
+func FinishScheduledTaskRun(ctx context.Context, dbPool *pgxpool.Pool, name string, scheduledFor time.Time, reason string) error {
+	status := models.ScheduledTaskSucceeded
+	var errorText *string
+	if reason != "" {
+		status = models.ScheduledTaskFailed
+		errorText = &reason
+	}
+
+	query := `
+		UPDATE scheduled_tasks SET status = $3, error = $4, finished_at = CURRENT_TIMESTAMP
+		WHERE name = $1 AND scheduled_for = $2`
+
+	_, err := dbPool.Exec(ctx, query, name, scheduledFor, status, errorText)
+	if err != nil {
+		return fmt.Errorf("failed to finish run of scheduled task %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// GetScheduledTaskRuns returns the latest run of every scheduled task that has run, by name
+func GetScheduledTaskRuns(ctx context.Context, dbPool *pgxpool.Pool) (map[string]models.ScheduledTaskRun, error) {
+	query := `SELECT name, scheduled_for, status, error, instance, started_at, finished_at FROM scheduled_tasks`
+
+	rows, err := dbPool.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve scheduled task runs: %w", err)
+	}
+
+	runs, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.ScheduledTaskRun])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect scheduled task runs: %w", err)
+	}
+
+	byName := make(map[string]models.ScheduledTaskRun, len(runs))
+	for _, run := range runs {
+		byName[run.Name] = run
+	}
+
+	return byName, nil
+}
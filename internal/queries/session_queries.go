@@ -0,0 +1,153 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrSessionNotFound is returned when a session does not exist, has expired or was revoked
+var ErrSessionNotFound = newKindError(ErrNotFound, "session not found")
+
+const sessionColumns = `id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at, organization_id`
+
+// CreateSession starts a new login session for the user, which refresh tokens are tied to.
+// The session starts in the first organization the user joined, which is returned along with the session id, or 0 if they have none.
+func CreateSession(ctx context.Context, dbPool *pgxpool.Pool, userID int, userAgent, ipAddress string, ttl time.Duration) (int64, int, error) {
+	args := pgx.NamedArgs{
+		"user_id":    userID,
+		"user_agent": userAgent,
+		"ip_address": ipAddress,
+		"ttl":        ttl,
+	}
+	query := `
+		INSERT INTO sessions (user_id, user_agent, ip_address, expires_at, organization_id)
+		VALUES (
+			@user_id, @user_agent, @ip_address, CURRENT_TIMESTAMP + @ttl::interval,
+			(SELECT organization_id FROM memberships WHERE user_id = @user_id ORDER BY created_at, organization_id LIMIT 1)
+		)
+		RETURNING id, COALESCE(organization_id, 0)`
+
+	var id int64
+	var organizationID int
+	err := dbPool.QueryRow(ctx, query, args).Scan(&id, &organizationID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create session for user %d: %w", userID, err)
+	}
+
+	return id, organizationID, nil
+}
+
+// TouchSession checks the session is still active for the user and records that it was used.
+// It returns the organization the session is working in, or 0 if it has none.
+func TouchSession(ctx context.Context, dbPool *pgxpool.Pool, id int64, userID int) (int, error) {
+	query := `
+		UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING COALESCE(organization_id, 0)`
+
+	var organizationID int
+	err := dbPool.QueryRow(ctx, query, id, userID).Scan(&organizationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update session %d: %w", id, err)
+	}
+
+	return organizationID, nil
+}
+
+// SetSessionOrganization switches the organization the session is working in, returning ErrMembershipNotFound
+// if the user is not a member of it
+func SetSessionOrganization(ctx context.Context, dbPool *pgxpool.Pool, id int64, userID, organizationID int) error {
+	query := `
+		UPDATE sessions SET organization_id = $3
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+			AND EXISTS (SELECT 1 FROM memberships WHERE organization_id = $3 AND user_id = $2)`
+
+	ct, err := dbPool.Exec(ctx, query, id, userID, organizationID)
+	if err != nil {
+		return fmt.Errorf("failed to switch organization of session %d: %w", id, err)
+	}
+
+	if ct.RowsAffected() == 0 {
+		return ErrMembershipNotFound
+	}
+
+	return nil
+}
+
+func GetSessionsForUser(ctx context.Context, dbPool *pgxpool.Pool, userID int) ([]models.Session, error) {
+	rows, err := dbPool.Query(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sessions for user %d: %w", userID, err)
+	}
+
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Session])
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect sessions for user %d: %w", userID, err)
+	}
+
+	return sessions, nil
+}
+
+// ChangePassword replaces the user's password hash and revokes every other session, so refresh tokens issued to
+// other devices stop working while the session the change was made from stays logged in
+func ChangePassword(ctx context.Context, dbPool *pgxpool.Pool, userID int, passwordHash string, currentSessionID int64) error {
+	return WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var email string
+		err := tx.QueryRow(ctx, `UPDATE users SET password = $2 WHERE id = $1 AND deleted_at IS NULL RETURNING email`, userID, passwordHash).Scan(&email)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update password for user %d: %w", userID, err)
+		}
+
+		ct, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`, userID, currentSessionID)
+		if err != nil {
+			return fmt.Errorf("failed to revoke sessions for user %d: %w", userID, err)
+		}
+
+		target := fmt.Sprintf("user:%d", userID)
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &email,
+			Action:     "users.change_password",
+			Target:     &target,
+			Details: map[string]any{
+				"revoked_sessions": ct.RowsAffected(),
+			},
+		})
+	})
+}
+
+// RehashPassword replaces the user's password hash with a new hash of the same password, without revoking any sessions
+func RehashPassword(ctx context.Context, dbPool *pgxpool.Pool, userID int, passwordHash string) error {
+	ct, err := dbPool.Exec(ctx, `UPDATE users SET password = $2 WHERE id = $1 AND deleted_at IS NULL`, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to rehash password for user %d: %w", userID, err)
+	}
+	if ct.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// PurgeExpiredSessions removes sessions that expired or were revoked more than the retention period ago
+func PurgeExpiredSessions(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) (int64, error) {
+	query := `DELETE FROM sessions WHERE COALESCE(revoked_at, expires_at) < CURRENT_TIMESTAMP - $1::interval`
+
+	ct, err := dbPool.Exec(ctx, query, retention)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired sessions: %w", err)
+	}
+
+	return ct.RowsAffected(), nil
+}
@@ -0,0 +1,86 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultTxAttempts = 3
+	txRetryBaseDelay  = 20 * time.Millisecond
+)
+
+// TxOptions configures a transaction run by WithTx, the zero value runs a read write transaction at the
+// database's default isolation level
+type TxOptions struct {
+	IsoLevel pgx.TxIsoLevel
+	ReadOnly bool
+	// MaxAttempts is how many times the transaction runs before a serialization failure or deadlock is returned, defaults to 3
+	MaxAttempts int
+}
+
+// WithTx runs fn in a transaction, committing it when fn returns nil and rolling it back otherwise.
+// Query functions that take a pgx.Tx can be called from fn to compose them into a single transaction.
+// Transactions that fail with a serialization failure or deadlock are retried from the start with jittered exponential
+// backoff, so fn must not have side effects outside of the transaction and is only safe to set results on success.
+func WithTx(ctx context.Context, dbPool *pgxpool.Pool, opts TxOptions, fn func(tx pgx.Tx) error) error {
+	attempts := opts.MaxAttempts
+	if attempts < 1 {
+		attempts = defaultTxAttempts
+	}
+
+	txOptions := pgx.TxOptions{IsoLevel: opts.IsoLevel}
+	if opts.ReadOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := runTx(ctx, dbPool, txOptions, fn)
+		if err == nil || attempt >= attempts || !isRetryableTxError(err) {
+			return err
+		}
+
+		delay := txRetryBaseDelay << (attempt - 1)
+		delay += rand.N(delay)
+		slog.Warn("Retrying transaction", "error", err, "attempt", attempt, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func runTx(ctx context.Context, dbPool *pgxpool.Pool, txOptions pgx.TxOptions, fn func(tx pgx.Tx) error) error {
+	tx, err := dbPool.BeginTx(ctx, txOptions)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = fn(tx); err != nil {
+		return MapConstraintError(err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return MapConstraintError(fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	return nil
+}
+
+// isRetryableTxError reports whether the transaction failed with a serialization failure or deadlock,
+// which succeed when the transaction is run again
+func isRetryableTxError(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
@@ -0,0 +1,84 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{err: &pgconn.PgError{Code: "40001"}, retryable: true},
+		{err: &pgconn.PgError{Code: "40P01"}, retryable: true},
+		{err: fmt.Errorf("failed to update user: %w", &pgconn.PgError{Code: "40001"}), retryable: true},
+		{err: fmt.Errorf("failed to commit transaction: %w", fmt.Errorf("wrapped twice: %w", &pgconn.PgError{Code: "40P01"})), retryable: true},
+		{err: &pgconn.PgError{Code: "23505"}, retryable: false},
+		{err: fmt.Errorf("failed to insert user: %w", &pgconn.PgError{Code: "55P03"}), retryable: false},
+		{err: errors.New("40001"), retryable: false},
+		{err: context.Canceled, retryable: false},
+		{err: nil, retryable: false},
+	}
+
+	for _, tt := range tests {
+		if retryable := isRetryableTxError(tt.err); retryable != tt.retryable {
+			t.Errorf("Expected %v to be retryable %t, got %t\n", tt.err, tt.retryable, retryable)
+		}
+	}
+}
+
+func TestWithTxRetries(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	dbPool, err := pgxpool.New(context.Background(), databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to the test database: %v\n", err)
+	}
+	defer dbPool.Close()
+
+	// failFirst fails the first attempts with the error and succeeds after that
+	failFirst := func(failures int, failure error) (func(tx pgx.Tx) error, *int) {
+		calls := 0
+		return func(tx pgx.Tx) error {
+			calls++
+			if calls <= failures {
+				return fmt.Errorf("failed to update user: %w", failure)
+			}
+			_, err := tx.Exec(context.Background(), `SELECT 1`)
+			return err
+		}, &calls
+	}
+	serializationFailure := &pgconn.PgError{Code: "40001"}
+
+	fn, calls := failFirst(1, serializationFailure)
+	if err = WithTx(context.Background(), dbPool, TxOptions{IsoLevel: pgx.Serializable}, fn); err != nil || *calls != 2 {
+		t.Errorf("Expected a serialization failure to be retried once and succeed, got %d calls and %v\n", *calls, err)
+	}
+
+	fn, calls = failFirst(5, serializationFailure)
+	if err = WithTx(context.Background(), dbPool, TxOptions{MaxAttempts: 2}, fn); !errors.Is(err, serializationFailure) || *calls != 2 {
+		t.Errorf("Expected the serialization failure after 2 attempts, got %d calls and %v\n", *calls, err)
+	}
+
+	fn, calls = failFirst(1, &pgconn.PgError{Code: "23505"})
+	if err = WithTx(context.Background(), dbPool, TxOptions{}, fn); !errors.Is(err, ErrConflict) || *calls != 1 {
+		t.Errorf("Expected a unique violation to be returned without retrying, got %d calls and %v\n", *calls, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fn, calls = failFirst(5, serializationFailure)
+	cancel()
+	if err = WithTx(ctx, dbPool, TxOptions{}, fn); err == nil || *calls > 1 {
+		t.Errorf("Expected a cancelled context to stop retries, got %d calls and %v\n", *calls, err)
+	}
+}
@@ -0,0 +1,102 @@
+package queries
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImportUser is a validated user to import, Row is the position of the user in the uploaded file
+type ImportUser struct {
+	Row  int
+	User models.User
+}
+
+// errDryRun rolls back the transaction of a dry run once it has found the duplicates
+var errDryRun = errors.New("dry run")
+
+// ImportUsers copies the users into a temporary table and inserts them in a single statement, along with a
+// users.created outbox event for each of them, skipping users whose email already exists. It returns the rows that
+// were skipped as duplicates. When dryRun is true the transaction is rolled back so nothing is inserted, but the
+// duplicates are still reported.
+func ImportUsers(ctx context.Context, dbPool *pgxpool.Pool, users []ImportUser, actorEmail string, dryRun bool) ([]int, error) {
+	var duplicateRows []int
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `CREATE TEMPORARY TABLE users_import (
+			row_number INT NOT NULL,
+			email VARCHAR(255) NOT NULL,
+			first_name VARCHAR(255),
+			last_name VARCHAR(255),
+			password VARCHAR(255) NOT NULL
+		) ON COMMIT DROP`)
+		if err != nil {
+			return fmt.Errorf("failed to create import table: %w", err)
+		}
+
+		_, err = tx.CopyFrom(
+			ctx,
+			pgx.Identifier{"users_import"},
+			[]string{"row_number", "email", "first_name", "last_name", "password"},
+			pgx.CopyFromSlice(len(users), func(i int) ([]any, error) {
+				user := users[i].User
+				return []any{users[i].Row, user.Email, user.FirstName, user.LastName, user.Password}, nil
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to copy users into import table: %w", err)
+		}
+
+		query := `
+			WITH inserted AS (
+				INSERT INTO users (email, first_name, last_name, password)
+				SELECT email, first_name, last_name, password FROM users_import ORDER BY row_number
+				ON CONFLICT DO NOTHING
+				RETURNING id, email, first_name, last_name
+			), events AS (
+				INSERT INTO outbox_events (aggregate_type, aggregate_id, event_type, payload)
+				SELECT 'user', id::text, $1, jsonb_build_object('id', id, 'email', email, 'first_name', first_name, 'last_name', last_name)
+				FROM inserted ORDER BY id
+			)
+			SELECT i.row_number FROM users_import i
+			WHERE NOT EXISTS (SELECT 1 FROM inserted WHERE inserted.email = i.email)
+			ORDER BY i.row_number`
+
+		rows, err := tx.Query(ctx, query, UserCreatedEvent)
+		if err != nil {
+			return fmt.Errorf("failed to insert imported users: %w", err)
+		}
+
+		duplicateRows, err = pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return fmt.Errorf("failed to collect duplicate import rows: %w", err)
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &actorEmail,
+			Action:     "users.import",
+			Details: map[string]any{
+				"imported":   len(users) - len(duplicateRows),
+				"duplicates": len(duplicateRows),
+			},
+		})
+	})
+	if errors.Is(err, errDryRun) {
+		return duplicateRows, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Users imported successfully", "imported", len(users)-len(duplicateRows), "duplicates", len(duplicateRows))
+
+	return duplicateRows, nil
+}
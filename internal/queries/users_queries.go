@@ -2,54 +2,434 @@ package queries
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/anishsharma21/go-backend-starter-template/internal/audit"
 	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func GetAllUsers(ctx context.Context, dbPool *pgxpool.Pool) ([]models.User, error) {
-	query := `SELECT id, first_name, last_name, email, created_at FROM users`
+// ErrUserNotFound is returned when a query targets a single user that does not exist
+var ErrUserNotFound = newKindError(ErrNotFound, "user not found")
 
-	rows, err := dbPool.Query(ctx, query)
-	defer rows.Close()
+// ErrUserVersionMismatch is returned when a user was changed since the version the caller based its update on
+var ErrUserVersionMismatch = newKindError(ErrConflict, "user version mismatch")
+
+const userColumns = `id, first_name, last_name, email, created_at, role, avatar_url, version, attributes`
+
+func GetAllUsers(ctx context.Context, dbPool *pgxpool.Pool, filter UserFilter) ([]models.User, error) {
+	where, args := filter.whereClause()
+	query := `SELECT ` + userColumns + ` FROM users ` + where + ` ORDER BY id`
+
+	rows, err := dbPool.Query(ctx, query, args)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to fetch users: %v\n", err)
+		return nil, fmt.Errorf("Failed to fetch users: %w\n", err)
 	}
+	defer rows.Close()
 
 	var users []models.User
 	users, err = pgx.CollectRows(rows, pgx.RowToStructByNameLax[models.User])
 	if err != nil {
-		return nil, fmt.Errorf("Failed to collect users: %v\n", err)
+		return nil, fmt.Errorf("Failed to collect users: %w\n", err)
 	}
 
 	return users, nil
 }
 
-func DeleteAllUsers(ctx context.Context, dbPool *pgxpool.Pool) error {
-	query := `DELETE FROM users`
+// StreamUsers calls fn for every user matched by the filter as rows arrive from the database, without holding the
+// whole result in memory. Iteration stops at the first error returned by fn.
+func StreamUsers(ctx context.Context, dbPool *pgxpool.Pool, filter UserFilter, fn func(models.User) error) error {
+	where, args := filter.whereClause()
+	query := `SELECT ` + userColumns + ` FROM users ` + where + ` ORDER BY id`
+
+	rows, err := dbPool.Query(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch users: %w\n", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := pgx.RowToStructByNameLax[models.User](rows)
+		if err != nil {
+			return fmt.Errorf("Failed to scan user: %w\n", err)
+		}
+
+		if err = fn(user); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("Failed to stream users: %w\n", err)
+	}
+
+	return nil
+}
+
+// UserFilter narrows down the users matched by a query, zero valued fields are ignored
+type UserFilter struct {
+	IDs           []int      `json:"ids,omitempty"`
+	Email         string     `json:"email,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	// Attributes matches users whose custom attributes contain all of these values
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// IsEmpty reports whether the filter would match every user
+func (f UserFilter) IsEmpty() bool {
+	return len(f.IDs) == 0 && f.Email == "" && f.CreatedAfter == nil && f.CreatedBefore == nil && len(f.Attributes) == 0
+}
+
+// whereClause builds the WHERE clause and arguments for the filter, soft deleted users are always excluded
+func (f UserFilter) whereClause() (string, pgx.NamedArgs) {
+	conditions := []string{"deleted_at IS NULL"}
+	args := pgx.NamedArgs{}
+
+	if len(f.IDs) > 0 {
+		conditions = append(conditions, "id = ANY(@ids)")
+		args["ids"] = f.IDs
+	}
+	if f.Email != "" {
+		conditions = append(conditions, "email ILIKE @email")
+		args["email"] = "%" + likeEscaper.Replace(f.Email) + "%"
+	}
+	if f.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= @created_after")
+		args["created_after"] = *f.CreatedAfter
+	}
+	if f.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < @created_before")
+		args["created_before"] = *f.CreatedBefore
+	}
+	if len(f.Attributes) > 0 {
+		conditions = append(conditions, "attributes @> @attributes")
+		args["attributes"] = f.Attributes
+	}
+
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// BulkDeleteUsers soft deletes every user matched by the filter and records an audit event in the same transaction.
+// When dryRun is true nothing is deleted and the users that would have been deleted are returned.
+func BulkDeleteUsers(ctx context.Context, dbPool *pgxpool.Pool, filter UserFilter, actorEmail string, dryRun bool) ([]models.User, error) {
+	if filter.IsEmpty() {
+		return nil, fmt.Errorf("Refusing to bulk delete users without ids or a filter\n")
+	}
+
+	where, args := filter.whereClause()
+	selectQuery := `SELECT ` + userColumns + ` FROM users ` + where + ` ORDER BY id`
+	// Only lock the users when deleting them, so a preview doesn't hold up writes to them
+	if !dryRun {
+		selectQuery += ` FOR UPDATE`
+	}
+
+	var users []models.User
+	err := WithTx(ctx, dbPool, TxOptions{ReadOnly: dryRun}, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, selectQuery, args)
+		if err != nil {
+			return fmt.Errorf("Failed to select users to delete: %w\n", err)
+		}
+
+		users, err = pgx.CollectRows(rows, pgx.RowToStructByNameLax[models.User])
+		if err != nil {
+			return fmt.Errorf("Failed to collect users to delete: %w\n", err)
+		}
+
+		if dryRun || len(users) == 0 {
+			return nil
+		}
 
-	slog.Info("Delete users transaction beginning...")
+		ids := make([]int, len(users))
+		for i, user := range users {
+			ids[i] = user.ID
+		}
 
-	tx, err := dbPool.Begin(ctx)
+		ct, err := tx.Exec(ctx, `UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ANY($1)`, ids)
+		if err != nil {
+			return fmt.Errorf("Failed to delete users: %w\n", err)
+		}
+
+		for _, id := range ids {
+			if err = insertUserEvent(ctx, tx, id, UserDeletedEvent, map[string]any{"id": id}); err != nil {
+				return err
+			}
+		}
+
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &actorEmail,
+			Action:     "users.bulk_delete",
+			Details: map[string]any{
+				"filter":   filter,
+				"user_ids": ids,
+				"count":    ct.RowsAffected(),
+			},
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("Failed to start transaction: %v\n", err)
+		return nil, err
+	}
+
+	if !dryRun && len(users) > 0 {
+		slog.Info("Bulk delete users transaction completed successfully.", "count", len(users), "actor", actorEmail)
 	}
-	defer tx.Rollback(ctx)
 
-	ct, err := tx.Exec(ctx, query)
+	return users, nil
+}
+
+// GetUserByID returns the active user with the id, or ErrUserNotFound
+func GetUserByID(ctx context.Context, dbPool *pgxpool.Pool, id int) (models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1 AND deleted_at IS NULL`
+
+	rows, err := dbPool.Query(ctx, query, id)
 	if err != nil {
-		return fmt.Errorf("Failed to delete users: %v\n", err)
+		return models.User{}, fmt.Errorf("Failed to query user with id %d: %w\n", id, err)
 	}
 
-	err = tx.Commit(ctx)
+	user, err := pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.User])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.User{}, ErrUserNotFound
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to commit transaction: %v\n", err)
+		return models.User{}, fmt.Errorf("Failed to collect user with id %d: %w\n", id, err)
 	}
 
-	slog.Info("Delete users transaction completed successfully.", "count", ct.RowsAffected())
+	return user, nil
+}
+
+// UserUpdate holds the fields of a user that can be edited, nil fields are left unchanged
+type UserUpdate struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Role      *string `json:"role"`
+	// Attributes replaces all of the user's custom attributes when set, it must already be validated against the registry
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// UpdateUser applies the update if the user is still at the expected version and records an audit event.
+// It returns ErrUserVersionMismatch along with the current user when someone else changed the user first.
+func UpdateUser(ctx context.Context, dbPool *pgxpool.Pool, id, expectedVersion int, update UserUpdate, actorEmail string) (models.User, error) {
+	var current, updated models.User
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id)
+		if err != nil {
+			return fmt.Errorf("Failed to select user with id %d: %w\n", id, err)
+		}
+
+		current, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.User])
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to collect user with id %d: %w\n", id, err)
+		}
+
+		if current.Version != expectedVersion {
+			return ErrUserVersionMismatch
+		}
+
+		query := `
+			UPDATE users SET
+				first_name = COALESCE(@first_name, first_name),
+				last_name = COALESCE(@last_name, last_name),
+				role = COALESCE(@role, role),
+				attributes = CASE WHEN @set_attributes THEN @attributes::jsonb ELSE attributes END
+			WHERE id = @id
+			RETURNING ` + userColumns
+
+		args := pgx.NamedArgs{
+			"id":             id,
+			"first_name":     update.FirstName,
+			"last_name":      update.LastName,
+			"role":           update.Role,
+			"set_attributes": update.Attributes != nil,
+			"attributes":     update.Attributes,
+		}
+
+		rows, err = tx.Query(ctx, query, args)
+		if err != nil {
+			return fmt.Errorf("Failed to update user with id %d: %w\n", id, err)
+		}
+
+		updated, err = pgx.CollectExactlyOneRow(rows, pgx.RowToStructByNameLax[models.User])
+		if err != nil {
+			return fmt.Errorf("Failed to collect updated user with id %d: %w\n", id, err)
+		}
+
+		before, after, err := audit.Diff(current, updated)
+		if err != nil {
+			return fmt.Errorf("Failed to diff updated user with id %d: %w\n", id, err)
+		}
+
+		err = insertUserEvent(ctx, tx, id, UserUpdatedEvent, map[string]any{"id": id, "changes": after})
+		if err != nil {
+			return err
+		}
+
+		target := fmt.Sprintf("user:%d", id)
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &actorEmail,
+			Action:     "users.update",
+			Target:     &target,
+			Before:     before,
+			After:      after,
+		})
+	})
+	if errors.Is(err, ErrUserVersionMismatch) {
+		return current, err
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	slog.Info("User updated successfully.", "id", id, "version", updated.Version, "actor", actorEmail)
+
+	return updated, nil
+}
+
+// DeleteUserByID soft deletes a single user, returning ErrUserNotFound if there is no active user with the id
+func DeleteUserByID(ctx context.Context, dbPool *pgxpool.Pool, id int, actorEmail string) error {
+	query := `UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL RETURNING deleted_at`
+
+	err := setUserDeletedAt(ctx, dbPool, id, query, "users.delete", actorEmail)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("User soft deleted successfully.", "id", id)
 
 	return nil
 }
+
+// ErrRestoreEmailTaken is returned when restoring a soft deleted user whose email now belongs to another user
+var ErrRestoreEmailTaken = newKindError(ErrConflict, "another user has taken the email of the deleted user")
+
+// RestoreUserByID clears the deleted_at timestamp of a soft deleted user, returning ErrUserNotFound if there is no soft deleted user with the id,
+// or ErrRestoreEmailTaken if another user signed up with their email while they were deleted
+func RestoreUserByID(ctx context.Context, dbPool *pgxpool.Pool, id int, actorEmail string) error {
+	query := `
+		UPDATE users SET deleted_at = NULL
+		FROM (SELECT deleted_at AS previous_deleted_at FROM users WHERE id = $1 FOR UPDATE) previous
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING previous.previous_deleted_at`
+
+	err := setUserDeletedAt(ctx, dbPool, id, query, "users.restore", actorEmail)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("User restored successfully.", "id", id)
+
+	return nil
+}
+
+// setUserDeletedAt runs a query that soft deletes or restores a user and returns the deleted_at timestamp that was
+// set or cleared, recording it in an audit event
+func setUserDeletedAt(ctx context.Context, dbPool *pgxpool.Pool, id int, query, action, actorEmail string) error {
+	return WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		var deletedAt time.Time
+		err := tx.QueryRow(ctx, query, id).Scan(&deletedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrRestoreEmailTaken
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to update deleted_at of user with id %d: %w\n", id, err)
+		}
+
+		before := map[string]any{"deleted_at": nil}
+		after := map[string]any{"deleted_at": deletedAt}
+		eventType := UserDeletedEvent
+		if action == "users.restore" {
+			before, after = after, before
+			eventType = UserRestoredEvent
+		}
+
+		if err = insertUserEvent(ctx, tx, id, eventType, map[string]any{"id": id}); err != nil {
+			return err
+		}
+
+		target := fmt.Sprintf("user:%d", id)
+		return InsertAuditEvent(ctx, tx, models.AuditEvent{
+			ActorEmail: &actorEmail,
+			Action:     action,
+			Target:     &target,
+			Before:     before,
+			After:      after,
+		})
+	})
+}
+
+// PurgeDeletedUsers permanently removes users that have been soft deleted for longer than the retention period.
+// It returns how many users were removed and the keys of their avatars, which are left for the caller to delete.
+func PurgeDeletedUsers(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) (int64, []string, error) {
+	var purged int64
+	var keys []string
+	err := WithTx(ctx, dbPool, TxOptions{}, func(tx pgx.Tx) error {
+		purged, keys = 0, nil
+
+		query := `DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < CURRENT_TIMESTAMP - $1::interval RETURNING id, avatar_key`
+
+		rows, err := tx.Query(ctx, query, retention)
+		if err != nil {
+			return fmt.Errorf("Failed to purge deleted users: %w\n", err)
+		}
+
+		type purgedUser struct {
+			ID        int
+			AvatarKey *string
+		}
+		users, err := pgx.CollectRows(rows, pgx.RowToStructByName[purgedUser])
+		if err != nil {
+			return fmt.Errorf("Failed to collect purged users: %w\n", err)
+		}
+
+		for _, user := range users {
+			if err = insertUserEvent(ctx, tx, user.ID, UserDeletedEvent, map[string]any{"id": user.ID, "permanent": true}); err != nil {
+				return err
+			}
+			if user.AvatarKey != nil {
+				keys = append(keys, *user.AvatarKey)
+			}
+		}
+
+		purged = int64(len(users))
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return purged, keys, nil
+}
+
+// SetUserAvatar points the user's avatar at a newly stored blob, returning the key of the previous avatar if there was one
+func SetUserAvatar(ctx context.Context, dbPool *pgxpool.Pool, userID int, key, url string) (*string, error) {
+	query := `
+		UPDATE users SET avatar_key = $2, avatar_url = $3
+		FROM (SELECT avatar_key AS previous_key FROM users WHERE id = $1 FOR UPDATE) previous
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING previous.previous_key`
+
+	var previousKey *string
+	err := dbPool.QueryRow(ctx, query, userID, key, url).Scan(&previousKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to set avatar for user %d: %w\n", userID, err)
+	}
+
+	return previousKey, nil
+}
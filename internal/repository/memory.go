@@ -0,0 +1,449 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// Memory is a thread safe in-memory implementation of the user and session repositories for tests. It follows the
+// same rules as the database, such as case insensitive unique emails and version bumps on every change, but it does
+// not record audit events or model organizations. Memberships can be added with AddMembership, while sessions always
+// start without an active organization.
+type Memory struct {
+	mu            sync.Mutex
+	users         map[int]models.User
+	sessions      map[int64]models.Session
+	memberships   map[[2]int]models.Membership
+	nextUserID    int
+	nextSessionID int64
+}
+
+var (
+	_ UserRepository    = (*Memory)(nil)
+	_ SessionRepository = (*Memory)(nil)
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:       map[int]models.User{},
+		sessions:    map[int64]models.Session{},
+		memberships: map[[2]int]models.Membership{},
+	}
+}
+
+func (m *Memory) SignUpNewUser(ctx context.Context, user models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emailTaken(user.Email) {
+		return 0, queries.ErrEmailTaken
+	}
+
+	now := time.Now()
+	m.nextUserID++
+	user.ID = m.nextUserID
+	user.CreatedAt = now
+	user.TokensValidAfter = &now
+	user.DeletedAt = nil
+	user.Version = 1
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
+	user.Attributes = cloneAttributes(user.Attributes)
+	if user.Attributes == nil {
+		user.Attributes = map[string]any{}
+	}
+	m.users[user.ID] = user
+
+	return user.ID, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.DeletedAt == nil && strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return models.User{}, queries.ErrUserNotFound
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt != nil {
+		return models.User{}, queries.ErrUserNotFound
+	}
+
+	return withoutSecrets(user), nil
+}
+
+func (m *Memory) GetAllUsers(ctx context.Context, filter queries.UserFilter) ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.matchingUsers(filter), nil
+}
+
+func (m *Memory) StreamUsers(ctx context.Context, filter queries.UserFilter, fn func(models.User) error) error {
+	m.mu.Lock()
+	users := m.matchingUsers(filter)
+	m.mu.Unlock()
+
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, id, expectedVersion int, update queries.UserUpdate, actorEmail string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt != nil {
+		return models.User{}, queries.ErrUserNotFound
+	}
+	if user.Version != expectedVersion {
+		return withoutSecrets(user), queries.ErrUserVersionMismatch
+	}
+
+	if update.FirstName != nil {
+		user.FirstName = update.FirstName
+	}
+	if update.LastName != nil {
+		user.LastName = update.LastName
+	}
+	if update.Role != nil {
+		user.Role = *update.Role
+	}
+	if update.Attributes != nil {
+		user.Attributes = cloneAttributes(update.Attributes)
+	}
+	user.Version++
+	m.users[id] = user
+
+	return withoutSecrets(user), nil
+}
+
+func (m *Memory) DeleteUserByID(ctx context.Context, id int, actorEmail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt != nil {
+		return queries.ErrUserNotFound
+	}
+
+	now := time.Now()
+	user.DeletedAt = &now
+	user.Version++
+	m.users[id] = user
+
+	return nil
+}
+
+func (m *Memory) RestoreUserByID(ctx context.Context, id int, actorEmail string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || user.DeletedAt == nil {
+		return queries.ErrUserNotFound
+	}
+	if m.emailTaken(user.Email) {
+		return queries.ErrRestoreEmailTaken
+	}
+
+	user.DeletedAt = nil
+	user.Version++
+	m.users[id] = user
+
+	return nil
+}
+
+func (m *Memory) BulkDeleteUsers(ctx context.Context, filter queries.UserFilter, actorEmail string, dryRun bool) ([]models.User, error) {
+	if filter.IsEmpty() {
+		return nil, fmt.Errorf("Refusing to bulk delete users without ids or a filter\n")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := m.matchingUsers(filter)
+	if dryRun {
+		return users, nil
+	}
+
+	now := time.Now()
+	for _, matched := range users {
+		user := m.users[matched.ID]
+		user.DeletedAt = &now
+		user.Version++
+		m.users[user.ID] = user
+	}
+
+	return users, nil
+}
+
+func (m *Memory) ChangePassword(ctx context.Context, userID int, passwordHash string, currentSessionID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return queries.ErrUserNotFound
+	}
+
+	user.Password = &passwordHash
+	user.Version++
+	m.users[userID] = user
+
+	now := time.Now()
+	for id, session := range m.sessions {
+		if session.UserID == userID && id != currentSessionID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+			m.sessions[id] = session
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) RehashPassword(ctx context.Context, userID int, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return queries.ErrUserNotFound
+	}
+
+	user.Password = &passwordHash
+	user.Version++
+	m.users[userID] = user
+
+	return nil
+}
+
+func (m *Memory) SetUserAvatar(ctx context.Context, userID int, key, url string) (*string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.DeletedAt != nil {
+		return nil, queries.ErrUserNotFound
+	}
+
+	previousKey := user.AvatarKey
+	user.AvatarKey = &key
+	user.AvatarURL = &url
+	m.users[userID] = user
+
+	return previousKey, nil
+}
+
+// AddMembership makes the user a member of the organization, for tests of routes that need an active organization
+func (m *Memory) AddMembership(membership models.Membership) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if membership.CreatedAt.IsZero() {
+		membership.CreatedAt = time.Now()
+	}
+	m.memberships[[2]int{membership.OrganizationID, membership.UserID}] = membership
+}
+
+func (m *Memory) GetMembership(ctx context.Context, organizationID, userID int) (models.Membership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	membership, ok := m.memberships[[2]int{organizationID, userID}]
+	if !ok {
+		return models.Membership{}, queries.ErrMembershipNotFound
+	}
+
+	return membership, nil
+}
+
+func (m *Memory) CreateSession(ctx context.Context, userID int, userAgent, ipAddress string, ttl time.Duration) (int64, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return 0, 0, fmt.Errorf("failed to create session for user %d: %w", userID, queries.ErrUserNotFound)
+	}
+
+	now := time.Now()
+	m.nextSessionID++
+	m.sessions[m.nextSessionID] = models.Session{
+		ID:         m.nextSessionID,
+		UserID:     userID,
+		UserAgent:  &userAgent,
+		IPAddress:  &ipAddress,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	return m.nextSessionID, 0, nil
+}
+
+func (m *Memory) TouchSession(ctx context.Context, id int64, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	session, ok := m.sessions[id]
+	if !ok || session.UserID != userID || session.RevokedAt != nil || !session.ExpiresAt.After(now) {
+		return 0, queries.ErrSessionNotFound
+	}
+
+	session.LastUsedAt = now
+	m.sessions[id] = session
+
+	if session.OrganizationID == nil {
+		return 0, nil
+	}
+	return *session.OrganizationID, nil
+}
+
+func (m *Memory) SetSessionOrganization(ctx context.Context, id int64, userID, organizationID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	_, isMember := m.memberships[[2]int{organizationID, userID}]
+	if !ok || session.UserID != userID || session.RevokedAt != nil || !isMember {
+		return queries.ErrMembershipNotFound
+	}
+
+	session.OrganizationID = &organizationID
+	m.sessions[id] = session
+
+	return nil
+}
+
+// emailTaken reports whether an active user has the email, like the partial unique index on emails.
+// The caller must hold the lock.
+func (m *Memory) emailTaken(email string) bool {
+	for _, user := range m.users {
+		if user.DeletedAt == nil && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingUsers returns the active users matched by the filter ordered by id, the same way UserFilter does in SQL.
+// The caller must hold the lock.
+func (m *Memory) matchingUsers(filter queries.UserFilter) []models.User {
+	var users []models.User
+	for _, user := range m.users {
+		if user.DeletedAt != nil {
+			continue
+		}
+		if len(filter.IDs) > 0 && !slices.Contains(filter.IDs, user.ID) {
+			continue
+		}
+		if filter.Email != "" && !strings.Contains(strings.ToLower(user.Email), strings.ToLower(filter.Email)) {
+			continue
+		}
+		if filter.CreatedAfter != nil && user.CreatedAt.Before(*filter.CreatedAfter) {
+			continue
+		}
+		if filter.CreatedBefore != nil && !user.CreatedAt.Before(*filter.CreatedBefore) {
+			continue
+		}
+		if !containsAttributes(user.Attributes, filter.Attributes) {
+			continue
+		}
+		users = append(users, withoutSecrets(user))
+	}
+
+	slices.SortFunc(users, func(a, b models.User) int { return a.ID - b.ID })
+
+	return users
+}
+
+// containsAttributes reports whether every filter value equals the user's attribute, comparing them by their JSON
+// values like jsonb containment does, so 42 and 42.0 are equal
+func containsAttributes(attributes, filter map[string]any) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	have, err := normalizeJSON(attributes)
+	if err != nil {
+		return false
+	}
+	want, err := normalizeJSON(filter)
+	if err != nil {
+		return false
+	}
+
+	for name, value := range want {
+		if actual, ok := have[name]; !ok || !reflect.DeepEqual(actual, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeJSON(values map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized map[string]any
+	if err = json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
+
+// copyUser returns a copy of the stored user that callers can change without affecting the store
+func copyUser(user models.User) models.User {
+	user.Attributes = cloneAttributes(user.Attributes)
+	return user
+}
+
+// withoutSecrets returns a copy of the user with only the columns the queries select for listings,
+// leaving out the password hash like the database implementation does
+func withoutSecrets(user models.User) models.User {
+	user = copyUser(user)
+	user.Password = nil
+	user.DeletedAt = nil
+	user.ErasureScheduledAt = nil
+	user.TokensValidAfter = nil
+	user.AvatarKey = nil
+	return user
+}
+
+func cloneAttributes(attributes map[string]any) map[string]any {
+	if attributes == nil {
+		return nil
+	}
+	return maps.Clone(attributes)
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// Postgres implements the repositories with the queries package. User listings and exports read from the replica when
+// the router has a healthy one, while writes and single user lookups, which back authentication and ETag checks and so
+// must see the latest writes, always use the primary. So do organizations, invitations and email changes, whose
+// callers usually read back what they just changed.
+type Postgres struct {
+	db *database.ReadRouter
+}
+
+var (
+	_ UserRepository         = (*Postgres)(nil)
+	_ SessionRepository      = (*Postgres)(nil)
+	_ OrganizationRepository = (*Postgres)(nil)
+	_ InvitationRepository   = (*Postgres)(nil)
+	_ EmailChangeRepository  = (*Postgres)(nil)
+	_ JobRepository          = (*Postgres)(nil)
+)
+
+func NewPostgres(db *database.ReadRouter) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) SignUpNewUser(ctx context.Context, user models.User) (int, error) {
+	return queries.SignUpNewUser(ctx, p.db.Primary(), user)
+}
+
+func (p *Postgres) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	return queries.GetUserByEmail(ctx, p.db.Primary(), email)
+}
+
+func (p *Postgres) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	return queries.GetUserByID(ctx, p.db.Primary(), id)
+}
+
+func (p *Postgres) GetAllUsers(ctx context.Context, filter queries.UserFilter) ([]models.User, error) {
+	return queries.GetAllUsers(ctx, p.db.Reader(), filter)
+}
+
+func (p *Postgres) StreamUsers(ctx context.Context, filter queries.UserFilter, fn func(models.User) error) error {
+	return queries.StreamUsers(ctx, p.db.Reader(), filter, fn)
+}
+
+func (p *Postgres) UpdateUser(ctx context.Context, id, expectedVersion int, update queries.UserUpdate, actorEmail string) (models.User, error) {
+	return queries.UpdateUser(ctx, p.db.Primary(), id, expectedVersion, update, actorEmail)
+}
+
+func (p *Postgres) DeleteUserByID(ctx context.Context, id int, actorEmail string) error {
+	return queries.DeleteUserByID(ctx, p.db.Primary(), id, actorEmail)
+}
+
+func (p *Postgres) RestoreUserByID(ctx context.Context, id int, actorEmail string) error {
+	return queries.RestoreUserByID(ctx, p.db.Primary(), id, actorEmail)
+}
+
+func (p *Postgres) BulkDeleteUsers(ctx context.Context, filter queries.UserFilter, actorEmail string, dryRun bool) ([]models.User, error) {
+	return queries.BulkDeleteUsers(ctx, p.db.Primary(), filter, actorEmail, dryRun)
+}
+
+func (p *Postgres) ChangePassword(ctx context.Context, userID int, passwordHash string, currentSessionID int64) error {
+	return queries.ChangePassword(ctx, p.db.Primary(), userID, passwordHash, currentSessionID)
+}
+
+func (p *Postgres) RehashPassword(ctx context.Context, userID int, passwordHash string) error {
+	return queries.RehashPassword(ctx, p.db.Primary(), userID, passwordHash)
+}
+
+func (p *Postgres) SetUserAvatar(ctx context.Context, userID int, key, url string) (*string, error) {
+	return queries.SetUserAvatar(ctx, p.db.Primary(), userID, key, url)
+}
+
+func (p *Postgres) GetMembership(ctx context.Context, organizationID, userID int) (models.Membership, error) {
+	return queries.GetMembership(ctx, p.db.Primary(), organizationID, userID)
+}
+
+func (p *Postgres) CreateSession(ctx context.Context, userID int, userAgent, ipAddress string, ttl time.Duration) (int64, int, error) {
+	return queries.CreateSession(ctx, p.db.Primary(), userID, userAgent, ipAddress, ttl)
+}
+
+func (p *Postgres) TouchSession(ctx context.Context, id int64, userID int) (int, error) {
+	return queries.TouchSession(ctx, p.db.Primary(), id, userID)
+}
+
+func (p *Postgres) SetSessionOrganization(ctx context.Context, id int64, userID, organizationID int) error {
+	return queries.SetSessionOrganization(ctx, p.db.Primary(), id, userID, organizationID)
+}
+
+func (p *Postgres) CreateOrganization(ctx context.Context, name, slug string, owner models.User) (models.Organization, error) {
+	return queries.CreateOrganization(ctx, p.db.Primary(), name, slug, owner)
+}
+
+func (p *Postgres) GetOrganizationsForUser(ctx context.Context, userID int) ([]models.UserOrganization, error) {
+	return queries.GetOrganizationsForUser(ctx, p.db.Primary(), userID)
+}
+
+func (p *Postgres) GetOrganization(ctx context.Context, scope queries.OrgScope) (models.Organization, error) {
+	return queries.GetOrganization(ctx, p.db.Primary(), scope)
+}
+
+func (p *Postgres) GetOrganizationMembers(ctx context.Context, scope queries.OrgScope) ([]models.OrganizationMember, error) {
+	return queries.GetOrganizationMembers(ctx, p.db.Primary(), scope)
+}
+
+func (p *Postgres) UpdateMemberRole(ctx context.Context, scope queries.OrgScope, userID int, role, actorEmail string) error {
+	return queries.UpdateMemberRole(ctx, p.db.Primary(), scope, userID, role, actorEmail)
+}
+
+func (p *Postgres) RemoveMember(ctx context.Context, scope queries.OrgScope, userID int, actorEmail string) error {
+	return queries.RemoveMember(ctx, p.db.Primary(), scope, userID, actorEmail)
+}
+
+func (p *Postgres) CreateInvitation(ctx context.Context, scope queries.OrgScope, email, role string, invitedBy models.User, ttl time.Duration) (models.Invitation, error) {
+	return queries.CreateInvitation(ctx, p.db.Primary(), scope, email, role, invitedBy, ttl)
+}
+
+func (p *Postgres) GetInvitationDetails(ctx context.Context, tokenHash string) (models.InvitationDetails, error) {
+	return queries.GetInvitationDetails(ctx, p.db.Primary(), tokenHash)
+}
+
+func (p *Postgres) AcceptInvitation(ctx context.Context, tokenHash string, user models.User) (models.Membership, error) {
+	return queries.AcceptInvitation(ctx, p.db.Primary(), tokenHash, user)
+}
+
+func (p *Postgres) SignUpInvitedUser(ctx context.Context, tokenHash string, user models.User) (int, error) {
+	return queries.SignUpInvitedUser(ctx, p.db.Primary(), tokenHash, user)
+}
+
+func (p *Postgres) EmailExists(ctx context.Context, email string) (bool, error) {
+	return queries.EmailExists(ctx, p.db.Primary(), email)
+}
+
+func (p *Postgres) CreateEmailChangeRequest(ctx context.Context, userID int, newEmail string, ttl time.Duration) error {
+	return queries.CreateEmailChangeRequest(ctx, p.db.Primary(), userID, newEmail, ttl)
+}
+
+func (p *Postgres) ConfirmEmailChange(ctx context.Context, tokenHash string) (string, models.EmailChangeRequest, error) {
+	return queries.ConfirmEmailChange(ctx, p.db.Primary(), tokenHash)
+}
+
+func (p *Postgres) EnqueueJob(ctx context.Context, args models.JobArgs, opts queries.JobOptions) (bool, error) {
+	return queries.EnqueueJob(ctx, p.db.Primary(), args, opts)
+}
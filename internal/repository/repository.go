@@ -0,0 +1,86 @@
+// Package repository defines the interfaces handlers use to load and store users, sessions, organizations,
+// invitations and email changes, so they can run against Postgres in production and against fakes in tests.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// UserRepository loads and changes users. Implementations return the queries package's errors, such as
+// queries.ErrUserNotFound, so callers handle them the same way whichever implementation is used.
+type UserRepository interface {
+	// SignUpNewUser inserts the user and returns their id, or queries.ErrEmailTaken if the email is already used
+	SignUpNewUser(ctx context.Context, user models.User) (int, error)
+	// GetUserByEmail returns the active user with the email, compared case insensitively, including their password hash.
+	// It returns queries.ErrUserNotFound when there is no such user.
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetAllUsers(ctx context.Context, filter queries.UserFilter) ([]models.User, error)
+	StreamUsers(ctx context.Context, filter queries.UserFilter, fn func(models.User) error) error
+	UpdateUser(ctx context.Context, id, expectedVersion int, update queries.UserUpdate, actorEmail string) (models.User, error)
+	DeleteUserByID(ctx context.Context, id int, actorEmail string) error
+	RestoreUserByID(ctx context.Context, id int, actorEmail string) error
+	BulkDeleteUsers(ctx context.Context, filter queries.UserFilter, actorEmail string, dryRun bool) ([]models.User, error)
+	// ChangePassword replaces the user's password hash and revokes every session except currentSessionID
+	ChangePassword(ctx context.Context, userID int, passwordHash string, currentSessionID int64) error
+	// RehashPassword replaces the user's password hash with a new hash of the same password, keeping their sessions
+	RehashPassword(ctx context.Context, userID int, passwordHash string) error
+	// SetUserAvatar points the user's avatar at a stored blob and returns the key of the previous one, if any
+	SetUserAvatar(ctx context.Context, userID int, key, url string) (*string, error)
+	// GetMembership returns the user's membership of the organization, or queries.ErrMembershipNotFound
+	GetMembership(ctx context.Context, organizationID, userID int) (models.Membership, error)
+}
+
+// SessionRepository manages the login sessions refresh tokens are tied to
+type SessionRepository interface {
+	CreateSession(ctx context.Context, userID int, userAgent, ipAddress string, ttl time.Duration) (int64, int, error)
+	TouchSession(ctx context.Context, id int64, userID int) (int, error)
+	// SetSessionOrganization switches the session to the organization, or returns queries.ErrMembershipNotFound if
+	// the user is not a member of it
+	SetSessionOrganization(ctx context.Context, id int64, userID, organizationID int) error
+}
+
+// OrganizationRepository manages organizations and their members. Methods taking a queries.OrgScope only see the
+// organization of the scope.
+type OrganizationRepository interface {
+	// CreateOrganization creates the organization with the owner as its first member, or returns queries.ErrSlugTaken
+	CreateOrganization(ctx context.Context, name, slug string, owner models.User) (models.Organization, error)
+	GetOrganizationsForUser(ctx context.Context, userID int) ([]models.UserOrganization, error)
+	GetMembership(ctx context.Context, organizationID, userID int) (models.Membership, error)
+	GetOrganization(ctx context.Context, scope queries.OrgScope) (models.Organization, error)
+	GetOrganizationMembers(ctx context.Context, scope queries.OrgScope) ([]models.OrganizationMember, error)
+	// UpdateMemberRole and RemoveMember return queries.ErrMembershipNotFound for users who aren't members, and
+	// queries.ErrLastOwner rather than leave the organization without an owner
+	UpdateMemberRole(ctx context.Context, scope queries.OrgScope, userID int, role, actorEmail string) error
+	RemoveMember(ctx context.Context, scope queries.OrgScope, userID int, actorEmail string) error
+}
+
+// InvitationRepository manages invitations to organizations. Invitations are looked up by the hash of their token,
+// and ones that are used or expired are reported as queries.ErrInvitationNotFound.
+type InvitationRepository interface {
+	// CreateInvitation replaces any earlier invitation of the email to the organization and queues the invite email
+	CreateInvitation(ctx context.Context, scope queries.OrgScope, email, role string, invitedBy models.User, ttl time.Duration) (models.Invitation, error)
+	GetInvitationDetails(ctx context.Context, tokenHash string) (models.InvitationDetails, error)
+	AcceptInvitation(ctx context.Context, tokenHash string, user models.User) (models.Membership, error)
+	// SignUpInvitedUser signs up the user and adds them to the organization, returning their id
+	SignUpInvitedUser(ctx context.Context, tokenHash string, user models.User) (int, error)
+}
+
+// EmailChangeRepository manages requests to change a user's email, which are confirmed by the hash of their token
+type EmailChangeRepository interface {
+	EmailExists(ctx context.Context, email string) (bool, error)
+	// CreateEmailChangeRequest replaces any pending request of the user and queues the confirmation email
+	CreateEmailChangeRequest(ctx context.Context, userID int, newEmail string, ttl time.Duration) error
+	// ConfirmEmailChange changes the email and returns the old one with the request, or queries.ErrEmailChangeNotFound
+	ConfirmEmailChange(ctx context.Context, tokenHash string) (string, models.EmailChangeRequest, error)
+}
+
+// JobRepository queues background jobs
+type JobRepository interface {
+	// EnqueueJob queues the job and reports whether it was added, which it isn't if it clashes with a unique job
+	EnqueueJob(ctx context.Context, args models.JobArgs, opts queries.JobOptions) (bool, error)
+}
@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the standard five fields: minute, hour, day of month, month and day of
+// week (0 or 7 is Sunday). Fields accept *, single values, ranges like 1-5, lists like 1,15 and steps like */15 or
+// 0-30/10. The @hourly, @daily, @weekly, @monthly and @yearly shorthands are also accepted. Times are in UTC.
+type Schedule struct {
+	expr                                   string
+	minutes, hours, daysOfMonth, months    uint64
+	daysOfWeek                             uint64
+	restrictedDayOfMonth, restrictedWeekly bool
+}
+
+var cronShorthands = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// ParseSchedule parses a cron expression
+func ParseSchedule(expr string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if shorthand, ok := cronShorthands[strings.TrimSpace(expr)]; ok {
+		fields = strings.Fields(shorthand)
+	}
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	s := Schedule{expr: expr}
+	var err error
+	if s.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return Schedule{}, fmt.Errorf("invalid minute in cron expression %q: %w", expr, err)
+	}
+	if s.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return Schedule{}, fmt.Errorf("invalid hour in cron expression %q: %w", expr, err)
+	}
+	if s.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return Schedule{}, fmt.Errorf("invalid day of month in cron expression %q: %w", expr, err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return Schedule{}, fmt.Errorf("invalid month in cron expression %q: %w", expr, err)
+	}
+	if s.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return Schedule{}, fmt.Errorf("invalid day of week in cron expression %q: %w", expr, err)
+	}
+	// Sunday can be written as 0 or 7
+	if s.daysOfWeek&(1<<7) != 0 {
+		s.daysOfWeek |= 1
+	}
+	// Like cron, a day field starting with * counts as unrestricted even with a step, such as */2
+	s.restrictedDayOfMonth = !strings.HasPrefix(fields[2], "*")
+	s.restrictedWeekly = !strings.HasPrefix(fields[4], "*")
+
+	return s, nil
+}
+
+func (s Schedule) String() string {
+	return s.expr
+}
+
+// Next returns the first time matching the schedule that is after t, or the zero time if there is none in the next
+// five years, such as for February 30th
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay follows cron in matching either the day of month or the day of week when both are restricted
+func (s Schedule) matchesDay(t time.Time) bool {
+	dayOfMonth := s.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.restrictedDayOfMonth && s.restrictedWeekly {
+		return dayOfMonth || dayOfWeek
+	}
+	return dayOfMonth && dayOfWeek
+}
+
+// parseCronField returns a bit set of the values the field matches
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		start, end := min, max
+		if valueRange != "*" {
+			startText, endText, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if start, err = strconv.Atoi(startText); err != nil {
+				return 0, fmt.Errorf("invalid value %q", startText)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endText); err != nil {
+					return 0, fmt.Errorf("invalid value %q", endText)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("%q is outside of %d-%d", part, min, max)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
@@ -0,0 +1,265 @@
+// Package scheduler runs periodic tasks on a cron schedule on exactly one instance of the application
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// leaderLockID is the session level advisory lock held by the scheduler leader for as long as it leads
+const leaderLockID = 7351208414620032002
+
+// Scheduler runs tasks on their cron schedules. Every instance starts one, and they elect a leader by taking a Postgres
+// advisory lock, which only the leader runs tasks on. The lock is held on a dedicated connection, so if the leader
+// dies its connection closes and another instance takes over at its next election.
+type Scheduler struct {
+	dbPool   *pgxpool.Pool
+	instance string
+	tasks    []*task
+	leader   atomic.Bool
+}
+
+type task struct {
+	name     string
+	schedule Schedule
+	run      func(ctx context.Context) error
+	// next is only used by the scheduler's loop
+	next    time.Time
+	running atomic.Bool
+}
+
+// TaskStatus describes a task, when it next runs if this instance is the leader then, and its latest run on any instance
+type TaskStatus struct {
+	Name      string                   `json:"name"`
+	Schedule  string                   `json:"schedule"`
+	NextRunAt time.Time                `json:"next_run_at"`
+	LastRun   *models.ScheduledTaskRun `json:"last_run"`
+}
+
+func New(dbPool *pgxpool.Pool) *Scheduler {
+	hostname, _ := os.Hostname()
+	return &Scheduler{dbPool: dbPool, instance: fmt.Sprintf("%s:%d", hostname, os.Getpid())}
+}
+
+// Add schedules the task with the cron expression, see Schedule. It must be called before Start.
+func (s *Scheduler) Add(name, expr string, run func(ctx context.Context) error) error {
+	schedule, err := ParseSchedule(expr)
+	if err != nil {
+		return fmt.Errorf("failed to schedule task %s: %w", name, err)
+	}
+
+	s.tasks = append(s.tasks, &task{name: name, schedule: schedule, run: run})
+	return nil
+}
+
+// IsLeader reports whether this instance is currently the one running tasks
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Status returns every task with its next run and latest run, in the order they were added
+func (s *Scheduler) Status(ctx context.Context) ([]TaskStatus, error) {
+	runs, err := queries.GetScheduledTaskRuns(ctx, s.dbPool)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	statuses := make([]TaskStatus, len(s.tasks))
+	for i, t := range s.tasks {
+		statuses[i] = TaskStatus{Name: t.name, Schedule: t.schedule.String(), NextRunAt: t.schedule.Next(now)}
+		if run, ok := runs[t.name]; ok {
+			statuses[i].LastRun = &run
+		}
+	}
+
+	return statuses, nil
+}
+
+// Start stands for election every electionInterval and, while this instance leads, runs tasks as they fall due,
+// until ctx is cancelled. A task that is still running when it is next due skips that run. On shutdown the running
+// tasks see ctx cancelled, and the returned channel is closed once they have returned and leadership was given up.
+func (s *Scheduler) Start(ctx context.Context, electionInterval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var running sync.WaitGroup
+		var lockConn *pgxpool.Conn
+		defer func() {
+			running.Wait()
+			if lockConn != nil {
+				s.resign(lockConn)
+			}
+			slog.Info("Scheduler stopped.")
+		}()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var lastElection time.Time
+		for {
+			if time.Since(lastElection) >= electionInterval {
+				lastElection = time.Now()
+				lockConn = s.elect(ctx, lockConn)
+			}
+			if lockConn != nil {
+				s.runDueTasks(ctx, &running)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+// elect checks that the leader still holds its lock, or tries to take the lock when this instance isn't leading,
+// and returns the connection holding the lock if this instance leads
+func (s *Scheduler) elect(ctx context.Context, lockConn *pgxpool.Conn) *pgxpool.Conn {
+	if lockConn != nil {
+		if _, err := lockConn.Exec(ctx, `SELECT 1`); err != nil {
+			if ctx.Err() != nil {
+				return lockConn
+			}
+			slog.Error("Lost connection holding the scheduler lock, no longer leading", "error", err)
+			// Closing the connection makes sure the session, and with it the lock, is gone before anyone else leads
+			lockConn.Conn().Close(context.WithoutCancel(ctx))
+			lockConn.Release()
+			s.leader.Store(false)
+			return nil
+		}
+		return lockConn
+	}
+
+	conn, err := s.dbPool.Acquire(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Failed to acquire connection for scheduler election", "error", err)
+		}
+		return nil
+	}
+
+	var locked bool
+	if err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, int64(leaderLockID)).Scan(&locked); err != nil || !locked {
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Failed to take the scheduler lock", "error", err)
+		}
+		conn.Release()
+		return nil
+	}
+
+	s.leader.Store(true)
+	s.loadNextRuns(ctx)
+	slog.Info("Became scheduler leader", "instance", s.instance)
+
+	return conn
+}
+
+// resign gives up the lock so another instance can lead straight away rather than once this connection closes
+func (s *Scheduler) resign(lockConn *pgxpool.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := lockConn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, int64(leaderLockID)); err != nil {
+		slog.Error("Failed to release the scheduler lock", "error", err)
+		lockConn.Conn().Close(ctx)
+	}
+	lockConn.Release()
+	s.leader.Store(false)
+}
+
+// loadNextRuns works out when each task is next due from its latest run, so a run missed while no instance was
+// leading happens straight away, once
+func (s *Scheduler) loadNextRuns(ctx context.Context) {
+	now := time.Now()
+	runs, err := queries.GetScheduledTaskRuns(ctx, s.dbPool)
+	if err != nil {
+		slog.Error("Failed to load latest scheduled task runs, scheduling from now", "error", err)
+	}
+
+	for _, t := range s.tasks {
+		if run, ok := runs[t.name]; ok {
+			t.next = t.schedule.Next(run.ScheduledFor)
+		} else {
+			t.next = t.schedule.Next(now)
+		}
+	}
+}
+
+func (s *Scheduler) runDueTasks(ctx context.Context, running *sync.WaitGroup) {
+	now := time.Now()
+	for _, t := range s.tasks {
+		if t.next.IsZero() || now.Before(t.next) {
+			continue
+		}
+
+		scheduledFor := t.next
+		t.next = t.schedule.Next(now)
+
+		if !t.running.CompareAndSwap(false, true) {
+			slog.Warn("Scheduled task is still running, skipping this run", "task", t.name, "scheduled_for", scheduledFor)
+			continue
+		}
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			defer t.running.Store(false)
+			s.runTask(ctx, t, scheduledFor)
+		}()
+	}
+}
+
+// runTask claims the run, so no other instance can start it too, then runs the task and records how it went
+func (s *Scheduler) runTask(ctx context.Context, t *task, scheduledFor time.Time) {
+	claimed, err := queries.ClaimScheduledTaskRun(ctx, s.dbPool, t.name, scheduledFor, s.instance)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Failed to claim scheduled task run", "error", err, "task", t.name)
+		}
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	started := time.Now()
+	err = runRecovered(ctx, t.run)
+
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+		slog.Error("Scheduled task failed", "error", err, "task", t.name, "scheduled_for", scheduledFor)
+	} else {
+		slog.Info("Scheduled task finished", "task", t.name, "scheduled_for", scheduledFor, "duration", time.Since(started))
+	}
+
+	if err = queries.FinishScheduledTaskRun(context.WithoutCancel(ctx), s.dbPool, t.name, scheduledFor, reason); err != nil {
+		slog.Error("Failed to record scheduled task run", "error", err, "task", t.name)
+	}
+}
+
+func runRecovered(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("scheduled task panicked: %v", r)
+		}
+	}()
+
+	return run(ctx)
+}
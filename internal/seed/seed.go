@@ -0,0 +1,222 @@
+// Package seed loads declarative fixtures of users and organizations, for local development and tests
+package seed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/attributes"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Fixtures are the users and organizations a database should contain
+type Fixtures struct {
+	Users         []User         `json:"users"`
+	Organizations []Organization `json:"organizations"`
+}
+
+// User is a user fixture, with its password in plain text so it can be used to log in
+type User struct {
+	Email      string         `json:"email"`
+	Password   string         `json:"password"`
+	FirstName  *string        `json:"first_name"`
+	LastName   *string        `json:"last_name"`
+	Role       string         `json:"role"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// Organization is an organization fixture, identified by its slug
+type Organization struct {
+	Name    string   `json:"name"`
+	Slug    string   `json:"slug"`
+	Members []Member `json:"members"`
+}
+
+// Member gives the user with the email a role in an organization
+type Member struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Load reads every JSON file in the set's directory, such as development or test, in name order and combines them
+func Load(fsys fs.FS, set string) (Fixtures, error) {
+	names, err := fs.Glob(fsys, path.Join(set, "*.json"))
+	if err != nil {
+		return Fixtures{}, fmt.Errorf("failed to list fixtures in %s: %w", set, err)
+	}
+	if len(names) == 0 {
+		return Fixtures{}, fmt.Errorf("no fixtures found for the %q set", set)
+	}
+	slices.Sort(names)
+
+	var fixtures Fixtures
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return Fixtures{}, fmt.Errorf("failed to read fixtures %s: %w", name, err)
+		}
+
+		var file Fixtures
+		if err := json.Unmarshal(data, &file); err != nil {
+			return Fixtures{}, fmt.Errorf("failed to parse fixtures %s: %w", name, err)
+		}
+		fixtures.Users = append(fixtures.Users, file.Users...)
+		fixtures.Organizations = append(fixtures.Organizations, file.Organizations...)
+	}
+
+	return fixtures, nil
+}
+
+// Target is where fixtures are applied. DB is only needed for organizations, so tests using the in-memory
+// repositories can apply user fixtures without a database. Registry validates the users' custom attributes when set.
+type Target struct {
+	Users    repository.UserRepository
+	DB       *pgxpool.Pool
+	Registry *attributes.Registry
+}
+
+// Apply creates the fixtures that don't exist yet and brings the ones that do back in line with them, so it can be
+// run any number of times. Users and organizations that aren't in the fixtures are left alone.
+func Apply(ctx context.Context, target Target, fixtures Fixtures) error {
+	if len(fixtures.Organizations) > 0 && target.DB == nil {
+		return fmt.Errorf("organization fixtures need a database connection")
+	}
+
+	userIDs := make(map[string]int, len(fixtures.Users))
+	for _, fixture := range fixtures.Users {
+		id, err := applyUser(ctx, target, fixture)
+		if err != nil {
+			return fmt.Errorf("failed to seed user %s: %w", fixture.Email, err)
+		}
+		userIDs[fixture.Email] = id
+	}
+
+	for _, fixture := range fixtures.Organizations {
+		members := make(map[int]string, len(fixture.Members))
+		for _, member := range fixture.Members {
+			id, ok := userIDs[member.Email]
+			if !ok {
+				return fmt.Errorf("organization %s has member %s, who is not one of the user fixtures", fixture.Slug, member.Email)
+			}
+			members[id] = member.Role
+		}
+
+		if _, err := queries.UpsertOrganization(ctx, target.DB, fixture.Name, fixture.Slug, members); err != nil {
+			return fmt.Errorf("failed to seed organization %s: %w", fixture.Slug, err)
+		}
+	}
+
+	slog.Info("Fixtures applied", "users", len(fixtures.Users), "organizations", len(fixtures.Organizations))
+
+	return nil
+}
+
+// applyUser creates the user, or updates an existing user whose details or password differ from the fixture,
+// and returns its id
+func applyUser(ctx context.Context, target Target, fixture User) (int, error) {
+	if fixture.Email == "" {
+		return 0, fmt.Errorf("email is required")
+	}
+	if err := validation.ValidatePassword(fixture.Password); err != nil {
+		return 0, err
+	}
+
+	role := fixture.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+	attributeValues := fixture.Attributes
+	if attributeValues == nil {
+		attributeValues = map[string]any{}
+	}
+	if target.Registry != nil {
+		validated, err := target.Registry.ValidateValues(attributeValues)
+		if err != nil {
+			return 0, err
+		}
+		attributeValues = validated
+	}
+
+	existing, err := target.Users.GetUserByEmail(ctx, fixture.Email)
+	if errors.Is(err, queries.ErrUserNotFound) {
+		passwordHash, err := hashPassword(fixture.Password)
+		if err != nil {
+			return 0, err
+		}
+
+		return target.Users.SignUpNewUser(ctx, models.User{
+			Email:      fixture.Email,
+			FirstName:  fixture.FirstName,
+			LastName:   fixture.LastName,
+			Password:   &passwordHash,
+			Role:       role,
+			Attributes: attributeValues,
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if existing.Password == nil || bcrypt.CompareHashAndPassword([]byte(*existing.Password), []byte(fixture.Password)) != nil {
+		passwordHash, err := hashPassword(fixture.Password)
+		if err != nil {
+			return 0, err
+		}
+		if err := target.Users.ChangePassword(ctx, existing.ID, passwordHash, 0); err != nil {
+			return 0, err
+		}
+		// Changing the password bumps the version the update below must expect
+		if existing, err = target.Users.GetUserByID(ctx, existing.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	update := queries.UserUpdate{Role: &role, FirstName: fixture.FirstName, LastName: fixture.LastName}
+	if equalJSON(existing.Attributes, attributeValues) {
+		if existing.Role == role && equalName(existing.FirstName, fixture.FirstName) && equalName(existing.LastName, fixture.LastName) {
+			return existing.ID, nil
+		}
+	} else {
+		update.Attributes = attributeValues
+	}
+
+	if _, err := target.Users.UpdateUser(ctx, existing.ID, existing.Version, update, "seed"); err != nil {
+		return 0, err
+	}
+
+	return existing.ID, nil
+}
+
+func hashPassword(password string) (string, error) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(passwordHash), nil
+}
+
+// equalName reports whether the fixture leaves the name unchanged, which it does when it doesn't set one
+func equalName(current, fixture *string) bool {
+	return fixture == nil || (current != nil && *current == *fixture)
+}
+
+// equalJSON compares attributes by their JSON encoding, which sorts keys and writes 42 and 42.0 the same way
+func equalJSON(a, b map[string]any) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// BlobStore stores binary objects such as uploaded images under string keys like "avatars/1/abc.png"
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients can download the object from
+	URL(key string) string
+}
+
+// NewBlobStoreFromEnv returns an S3 compatible blob store when BLOB_STORE=s3, otherwise a store on the local filesystem
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	if os.Getenv("BLOB_STORE") != "s3" {
+		dir := os.Getenv("UPLOADS_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		slog.Info("Using local filesystem blob store", "dir", dir)
+		return NewLocalBlobStore(dir, "/uploads")
+	}
+
+	store := &S3BlobStore{
+		Endpoint:        strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		Region:          os.Getenv("S3_REGION"),
+		Bucket:          os.Getenv("S3_BUCKET"),
+		AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		PublicURL:       strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/"),
+	}
+	if store.Endpoint == "" || store.Bucket == "" || store.AccessKeyID == "" || store.SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required when BLOB_STORE=s3")
+	}
+	if store.Region == "" {
+		store.Region = "us-east-1"
+	}
+
+	slog.Info("Using S3 compatible blob store", "endpoint", store.Endpoint, "bucket", store.Bucket)
+	return store, nil
+}
+
+// validateKey rejects keys that could escape the store, such as absolute paths or ".." segments
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalBlobStore keeps blobs as files in a directory, which Handler serves under BaseURL
+type LocalBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalBlobStore(dir, baseURL string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory %q: %v", dir, err)
+	}
+
+	return &LocalBlobStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Put writes the blob to a temporary file first so readers never see a partially written blob
+func (s *LocalBlobStore) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for blob %q: %v", key, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for blob %q: %v", key, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob %q: %v", key, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob %q: %v", key, err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store blob %q: %v", key, err)
+	}
+
+	return nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob %q: %v", key, err)
+	}
+
+	return nil
+}
+
+func (s *LocalBlobStore) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+// Handler serves the stored blobs, it must be mounted with the BaseURL prefix stripped. Directory listings are not served.
+func (s *LocalBlobStore) Handler() http.Handler {
+	fileServer := http.FileServer(http.Dir(s.Dir))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if validateKey(strings.TrimPrefix(r.URL.Path, "/")) != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,25 @@
+// Package tokens generates the single use tokens sent to users in links, such as email change confirmations and invitations
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Generate returns a random single use token to send to the user, and the hash of it to store in the database
+func Generate() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// Hash returns the hash of a token that is stored in the database, so a token can be looked up without storing it
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type AuditEvent struct {
+	ID         int64          `json:"id"`
+	ActorEmail *string        `json:"actor_email"`
+	Action     string         `json:"action"`
+	Target     *string        `json:"target"`
+	Details    map[string]any `json:"details"`
+	IPAddress  *string        `json:"ip_address"`
+	RequestID  *string        `json:"request_id"`
+	// Before and After hold the fields the audited change modified, with their old and new values
+	Before    map[string]any `json:"before,omitempty"`
+	After     map[string]any `json:"after,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
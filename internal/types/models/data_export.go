@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+	DataExportPending    = "pending"
+	DataExportProcessing = "processing"
+	DataExportReady      = "ready"
+	DataExportFailed     = "failed"
+)
+
+type DataExport struct {
+	ID          int64      `json:"id"`
+	UserID      int        `json:"user_id"`
+	Status      string     `json:"status"`
+	Archive     []byte     `json:"-"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// UserData is everything stored about a single user, assembled for data export requests
+type UserData struct {
+	Profile     User         `json:"profile"`
+	Sessions    []Session    `json:"sessions"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+	DataExports []DataExport `json:"data_exports"`
+}
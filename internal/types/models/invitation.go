@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+type Invitation struct {
+	ID             int64      `json:"id"`
+	OrganizationID int        `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	InvitedBy      *int       `json:"invited_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy     *int       `json:"accepted_by,omitempty"`
+}
+
+// InvitationEmail is what the email sent to an invited address says. InvitedByEmail is nil once the inviter's
+// account is deleted.
+type InvitationEmail struct {
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	OrganizationName string    `json:"organization_name"`
+	InvitedByEmail   *string   `json:"invited_by_email"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// InvitationDetails is what the holder of an invite link is shown before accepting it
+type InvitationDetails struct {
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	OrganizationName string    `json:"organization_name"`
+	OrganizationSlug string    `json:"organization_slug"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	// ExistingUser tells the client whether to ask the invitee to log in or to sign up
+	ExistingUser bool `json:"existing_user"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// JobArgs are the arguments of a kind of background job, stored as JSON with the job
+type JobArgs interface {
+	Kind() string
+}
+
+// Job is a background job claimed by a worker, with its arguments still encoded
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Args        json.RawMessage `json:"args"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+}
+
+// SendEmailJob sends a transactional email
+type SendEmailJob struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+func (SendEmailJob) Kind() string { return "emails.send" }
+
+// EmailChangeConfirmationJob emails the link confirming a pending email change to the new address. The link's token is
+// issued when the email is sent, so it is never stored with the job.
+type EmailChangeConfirmationJob struct {
+	EmailChangeRequestID int64 `json:"email_change_request_id"`
+}
+
+func (EmailChangeConfirmationJob) Kind() string { return "email_changes.send_confirmation" }
+
+// InvitationEmailJob emails an invite link to the invited address, issuing its token when the email is sent
+type InvitationEmailJob struct {
+	InvitationID int64 `json:"invitation_id"`
+}
+
+func (InvitationEmailJob) Kind() string { return "invitations.send" }
+
+// DataExportJob generates the archive of a requested data export
+type DataExportJob struct {
+	DataExportID int64 `json:"data_export_id"`
+}
+
+func (DataExportJob) Kind() string { return "data_exports.generate" }
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// OutboxEvent is a domain event waiting to be, or already, delivered to other systems.
+// Events about the same aggregate, such as one user, are delivered in the order they were written.
+type OutboxEvent struct {
+	ID            int64          `json:"id"`
+	AggregateType string         `json:"aggregate_type"`
+	AggregateID   string         `json:"aggregate_id"`
+	EventType     string         `json:"event_type"`
+	Payload       map[string]any `json:"payload"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Attempts      int            `json:"attempts"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Statuses of a scheduled task's latest run
+const (
+	ScheduledTaskRunning   = "running"
+	ScheduledTaskSucceeded = "succeeded"
+	ScheduledTaskFailed    = "failed"
+)
+
+// ScheduledTaskRun is the latest run of a scheduled task
+type ScheduledTaskRun struct {
+	Name         string     `json:"name"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Status       string     `json:"status"`
+	Error        *string    `json:"error"`
+	Instance     string     `json:"instance"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type Session struct {
+	ID         int64      `json:"id"`
+	UserID     int        `json:"user_id"`
+	UserAgent  *string    `json:"user_agent"`
+	IPAddress  *string    `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// OrganizationID is the organization the session is working in
+	OrganizationID *int `json:"organization_id"`
+}
@@ -2,11 +2,24 @@ package models
 
 import "time"
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID        int       `json:"id"`
-	FirstName *string   `json:"first_name"`
-	LastName  *string   `json:"last_name"`
-	Email     string    `json:"email"`
-	Password  *string   `json:"password,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                 int            `json:"id"`
+	FirstName          *string        `json:"first_name"`
+	LastName           *string        `json:"last_name"`
+	Email              string         `json:"email"`
+	Password           *string        `json:"password,omitempty"`
+	CreatedAt          time.Time      `json:"created_at"`
+	DeletedAt          *time.Time     `json:"deleted_at,omitempty"`
+	Role               string         `json:"role"`
+	ErasureScheduledAt *time.Time     `json:"erasure_scheduled_at,omitempty"`
+	TokensValidAfter   *time.Time     `json:"-"`
+	AvatarURL          *string        `json:"avatar_url"`
+	AvatarKey          *string        `json:"-"`
+	Version            int            `json:"version"`
+	Attributes         map[string]any `json:"attributes"`
 }
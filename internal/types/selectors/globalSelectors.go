@@ -7,3 +7,11 @@ type indexPage struct {
 var IndexPage = indexPage{
 	BaseHTML: "base-html",
 }
+
+type confirmEmailPage struct {
+	BaseHTML string
+}
+
+var ConfirmEmailPage = confirmEmailPage{
+	BaseHTML: "confirm-email-html",
+}
@@ -0,0 +1,49 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxEmailLength     = 254
+	maxEmailLocalBytes = 64
+)
+
+// NormalizeEmail validates the address against RFC 5322 and returns the canonical form used as a user's identity:
+// a bare address with the domain converted to its ASCII (punycode) form and the whole address lower cased, so that
+// Bob@Example.com, bob@example.com and bob@EXAMPLE.com are the same account.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", fmt.Errorf("email is required")
+	}
+
+	// Only bare addresses are accepted, not display names such as "Bob <bob@example.com>"
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return "", fmt.Errorf("invalid email address")
+	}
+
+	at := strings.LastIndex(email, "@")
+	local, domain := email[:at], email[at+1:]
+
+	if len(local) > maxEmailLocalBytes {
+		return "", fmt.Errorf("email local part must be at most %d bytes", maxEmailLocalBytes)
+	}
+
+	asciiDomain, err := idna.Lookup.ToASCII(domain)
+	if err != nil || !strings.Contains(asciiDomain, ".") {
+		return "", fmt.Errorf("invalid email domain")
+	}
+
+	normalized := strings.ToLower(local + "@" + asciiDomain)
+	if len(normalized) > maxEmailLength {
+		return "", fmt.Errorf("email must be at most %d characters", maxEmailLength)
+	}
+
+	return normalized, nil
+}
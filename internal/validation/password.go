@@ -0,0 +1,30 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 8
+	// MaxPasswordBytes is the most bcrypt will hash, anything longer would be silently truncated
+	MaxPasswordBytes = 72
+)
+
+// ValidatePassword applies the password policy, returning an error describing the first rule the password breaks
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	if len(password) > MaxPasswordBytes {
+		return fmt.Errorf("password must be at most %d bytes", MaxPasswordBytes)
+	}
+
+	if strings.TrimSpace(password) == "" {
+		return fmt.Errorf("password must not be only whitespace")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package workers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/jobs"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// generateDataExport builds the archive of the job's data export and stores it, marking the export as failed
+// once the job has run out of attempts
+func generateDataExport(dbPool *pgxpool.Pool) func(ctx context.Context, job jobs.Job[models.DataExportJob]) error {
+	return func(ctx context.Context, job jobs.Job[models.DataExportJob]) error {
+		id := job.Args.DataExportID
+
+		dataExport, err := queries.StartDataExport(ctx, dbPool, id)
+		if errors.Is(err, queries.ErrDataExportNotFound) {
+			slog.Info("Data export already finished or removed, skipping", "id", id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		archive, err := BuildDataExportArchive(ctx, dbPool, dataExport.UserID)
+		if err != nil {
+			if job.LastAttempt() {
+				if failErr := queries.FailDataExport(ctx, dbPool, id, "failed to build archive"); failErr != nil {
+					slog.Error("Failed to mark data export as failed", "error", failErr, "id", id)
+				}
+			}
+			return fmt.Errorf("failed to build data export archive: %w", err)
+		}
+
+		if err = queries.CompleteDataExport(ctx, dbPool, id, archive); err != nil {
+			return err
+		}
+
+		slog.Info("Data export generated", "id", id, "user_id", dataExport.UserID, "bytes", len(archive))
+		return nil
+	}
+}
+
+// BuildDataExportArchive assembles everything stored about the user into a zip archive with one JSON file per kind of data
+func BuildDataExportArchive(ctx context.Context, dbPool *pgxpool.Pool, userID int) ([]byte, error) {
+	data, err := queries.GetUserData(ctx, dbPool, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]any{
+		"profile.json":      data.Profile,
+		"sessions.json":     data.Sessions,
+		"audit_events.json": data.AuditEvents,
+		"data_exports.json": data.DataExports,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"profile.json", "sessions.json", "audit_events.json", "data_exports.json"} {
+		f, err := zw.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to archive: %v", name, err)
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(files[name]); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+	}
+
+	if err = zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
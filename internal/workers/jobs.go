@@ -0,0 +1,25 @@
+package workers
+
+import (
+	"context"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/jobs"
+	"github.com/anishsharma21/go-backend-starter-template/internal/mailer"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegisterJobs registers the handlers for every kind of background job the application queues. Links in emails point
+// at appURL.
+func RegisterJobs(w *jobs.Workers, dbPool *pgxpool.Pool, m mailer.Mailer, appURL string) {
+	jobs.Register(w, sendEmail(m))
+	jobs.Register(w, sendEmailChangeConfirmation(dbPool, m, appURL))
+	jobs.Register(w, sendInvitation(dbPool, m, appURL))
+	jobs.Register(w, generateDataExport(dbPool))
+}
+
+func sendEmail(m mailer.Mailer) func(ctx context.Context, job jobs.Job[models.SendEmailJob]) error {
+	return func(ctx context.Context, job jobs.Job[models.SendEmailJob]) error {
+		return m.Send(ctx, mailer.Message{To: job.Args.To, Subject: job.Args.Subject, Body: job.Args.Body})
+	}
+}
@@ -0,0 +1,70 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/outbox"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	outboxBatchSize     = 100
+	outboxRetryBase     = time.Second
+	outboxRetryMaxDelay = time.Hour
+)
+
+// StartOutboxRelay delivers pending outbox events to the sink, polling for new events on every interval until ctx is
+// cancelled. Events that fail are retried with exponential backoff. The returned channel is closed once the worker has stopped.
+func StartOutboxRelay(ctx context.Context, dbPool *pgxpool.Pool, sink outbox.Sink, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			relayOutboxEvents(ctx, dbPool, sink)
+
+			select {
+			case <-ctx.Done():
+				slog.Info("Outbox relay worker stopped.")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return done
+}
+
+func relayOutboxEvents(ctx context.Context, dbPool *pgxpool.Pool, sink outbox.Sink) {
+	for ctx.Err() == nil {
+		published, failed, err := queries.RelayOutboxEvents(ctx, dbPool, outboxBatchSize, sink.Publish, outboxRetryDelay)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to relay outbox events", "error", err)
+			}
+			return
+		}
+		if failed > 0 {
+			slog.Warn("Failed to deliver outbox events, they will be retried", "published", published, "failed", failed)
+		}
+		// Keep going while there are full batches of events waiting
+		if published+failed < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// outboxRetryDelay doubles the delay before retrying an event with every failed attempt, up to an hour
+func outboxRetryDelay(attempts int) time.Duration {
+	if attempts > 12 {
+		return outboxRetryMaxDelay
+	}
+	return min(outboxRetryBase<<(attempts-1), outboxRetryMaxDelay)
+}
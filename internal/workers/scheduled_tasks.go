@@ -0,0 +1,144 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/scheduler"
+	"github.com/anishsharma21/go-backend-starter-template/internal/storage"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RegisterScheduledTasks schedules the periodic cleanup tasks: permanently removing soft deleted users once they have been
+// deleted for longer than the retention period, erasing users whose erasure cooling off period has ended, removing expired
+// data export archives, email change requests, invitations and sessions, and removing audit events older than the audit
+// retention period, outbox events published longer ago than the outbox retention period and dead jobs older than the
+// dead job retention period.
+func RegisterScheduledTasks(s *scheduler.Scheduler, dbPool *pgxpool.Pool, blobs storage.BlobStore, retention, auditRetention, outboxRetention, deadJobRetention time.Duration) error {
+	return errors.Join(
+		s.Add("purge_deleted_users", "@hourly", func(ctx context.Context) error {
+			return purgeDeletedUsers(ctx, dbPool, blobs, retention)
+		}),
+		s.Add("erase_scheduled_users", "*/15 * * * *", func(ctx context.Context) error {
+			return eraseScheduledUsers(ctx, dbPool, blobs)
+		}),
+		s.Add("purge_expired_tokens", "*/15 * * * *", func(ctx context.Context) error {
+			return purgeExpiredTokens(ctx, dbPool)
+		}),
+		s.Add("purge_expired_data_exports", "@hourly", func(ctx context.Context) error {
+			return purgeExpiredDataExports(ctx, dbPool)
+		}),
+		s.Add("purge_audit_events", "30 3 * * *", func(ctx context.Context) error {
+			return purgeAuditEvents(ctx, dbPool, auditRetention)
+		}),
+		s.Add("purge_published_outbox_events", "45 3 * * *", func(ctx context.Context) error {
+			return purgePublishedOutboxEvents(ctx, dbPool, outboxRetention)
+		}),
+		s.Add("purge_dead_jobs", "15 4 * * *", func(ctx context.Context) error {
+			return purgeDeadJobs(ctx, dbPool, deadJobRetention)
+		}),
+	)
+}
+
+func purgeDeletedUsers(ctx context.Context, dbPool *pgxpool.Pool, blobs storage.BlobStore, retention time.Duration) error {
+	count, avatarKeys, err := queries.PurgeDeletedUsers(ctx, dbPool, retention)
+	if err != nil {
+		return err
+	}
+	deleteBlobs(ctx, blobs, avatarKeys)
+
+	if count > 0 {
+		slog.Info("Purged deleted users past retention period", "count", count, "retention", retention.String())
+	}
+	return nil
+}
+
+func eraseScheduledUsers(ctx context.Context, dbPool *pgxpool.Pool, blobs storage.BlobStore) error {
+	count, avatarKeys, err := queries.EraseScheduledUsers(ctx, dbPool)
+	// Users erased before a failure are gone, so their avatars are deleted either way
+	deleteBlobs(ctx, blobs, avatarKeys)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		slog.Info("Erased users past their cooling off period", "count", count)
+	}
+	return nil
+}
+
+// dataExportRetention is how long a generated data export archive can be downloaded for
+const dataExportRetention = 7 * 24 * time.Hour
+
+func purgeExpiredDataExports(ctx context.Context, dbPool *pgxpool.Pool) error {
+	count, err := queries.PurgeExpiredDataExports(ctx, dbPool, dataExportRetention)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		slog.Info("Purged expired data exports", "count", count)
+	}
+	return nil
+}
+
+// sessionRetention is how long expired and revoked sessions are kept, so they still show up in data exports for a while
+const sessionRetention = 30 * 24 * time.Hour
+
+// purgeExpiredTokens removes expired email change requests, invitations and sessions, carrying on past a failure so
+// one of them can't hold up the others
+func purgeExpiredTokens(ctx context.Context, dbPool *pgxpool.Pool) error {
+	_, emailChangeErr := queries.PurgeExpiredEmailChangeRequests(ctx, dbPool)
+	_, invitationErr := queries.PurgeExpiredInvitations(ctx, dbPool)
+	_, sessionErr := queries.PurgeExpiredSessions(ctx, dbPool, sessionRetention)
+
+	return errors.Join(emailChangeErr, invitationErr, sessionErr)
+}
+
+func purgeAuditEvents(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) error {
+	count, err := queries.PurgeAuditEvents(ctx, dbPool, retention)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		slog.Info("Purged audit events past retention period", "count", count, "retention", retention.String())
+	}
+	return nil
+}
+
+func purgePublishedOutboxEvents(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) error {
+	count, err := queries.PurgePublishedOutboxEvents(ctx, dbPool, retention)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		slog.Info("Purged published outbox events past retention period", "count", count, "retention", retention.String())
+	}
+	return nil
+}
+
+func purgeDeadJobs(ctx context.Context, dbPool *pgxpool.Pool, retention time.Duration) error {
+	count, err := queries.PurgeDeadJobs(ctx, dbPool, retention)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		slog.Info("Purged dead jobs past retention period", "count", count, "retention", retention.String())
+	}
+	return nil
+}
+
+// deleteBlobs removes blobs, such as avatars, that belonged to users who no longer exist
+func deleteBlobs(ctx context.Context, blobs storage.BlobStore, keys []string) {
+	for _, key := range keys {
+		if err := blobs.Delete(ctx, key); err != nil {
+			slog.Error("Failed to delete blob of removed user", "error", err, "key", key)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/jobs"
+	"github.com/anishsharma21/go-backend-starter-template/internal/mailer"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/tokens"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// expiryFormat is how emails say when the link they carry stops working
+const expiryFormat = "2 January 2006 at 15:04 UTC"
+
+// sendEmailChangeConfirmation issues a token for the job's email change request and emails the link confirming it to
+// the new address. Every attempt issues a new token, so only the link in the latest email works.
+func sendEmailChangeConfirmation(dbPool *pgxpool.Pool, m mailer.Mailer, appURL string) func(ctx context.Context, job jobs.Job[models.EmailChangeConfirmationJob]) error {
+	return func(ctx context.Context, job jobs.Job[models.EmailChangeConfirmationJob]) error {
+		id := job.Args.EmailChangeRequestID
+
+		token, tokenHash, err := tokens.Generate()
+		if err != nil {
+			return fmt.Errorf("failed to generate email change token: %w", err)
+		}
+
+		request, err := queries.IssueEmailChangeToken(ctx, dbPool, id, tokenHash)
+		if errors.Is(err, queries.ErrEmailChangeNotFound) {
+			slog.Info("Email change request already confirmed, expired or replaced, skipping", "id", id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return m.Send(ctx, mailer.Message{
+			To:      request.NewEmail,
+			Subject: "Confirm your new email address",
+			Body: fmt.Sprintf(
+				"Confirm this email address by opening the link below before %s.\n\n%s/email/confirm?token=%s\n\nIf you did not request this change you can ignore this email.",
+				request.ExpiresAt.UTC().Format(expiryFormat), appURL, url.QueryEscape(token),
+			),
+		})
+	}
+}
+
+// sendInvitation issues a token for the job's invitation and emails the invite link to the invited address. Every
+// attempt issues a new token, so only the link in the latest email works.
+func sendInvitation(dbPool *pgxpool.Pool, m mailer.Mailer, appURL string) func(ctx context.Context, job jobs.Job[models.InvitationEmailJob]) error {
+	return func(ctx context.Context, job jobs.Job[models.InvitationEmailJob]) error {
+		id := job.Args.InvitationID
+
+		token, tokenHash, err := tokens.Generate()
+		if err != nil {
+			return fmt.Errorf("failed to generate invitation token: %w", err)
+		}
+
+		invitation, err := queries.IssueInvitationToken(ctx, dbPool, id, tokenHash)
+		if errors.Is(err, queries.ErrInvitationNotFound) {
+			slog.Info("Invitation already accepted, expired or replaced, skipping", "id", id)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		inviter := "Someone"
+		if invitation.InvitedByEmail != nil {
+			inviter = *invitation.InvitedByEmail
+		}
+
+		return m.Send(ctx, mailer.Message{
+			To:      invitation.Email,
+			Subject: fmt.Sprintf("You have been invited to join %s", invitation.OrganizationName),
+			Body: fmt.Sprintf(
+				"%s has invited you to join %s as %s %s.\n\nAccept the invitation before %s at %s/invitations/%s\n\nIf you were not expecting this invitation you can ignore this email.",
+				inviter, invitation.OrganizationName, article(invitation.Role), invitation.Role,
+				invitation.ExpiresAt.UTC().Format(expiryFormat), appURL, token,
+			),
+		})
+	}
+}
+
+func article(role string) string {
+	if role == models.OrgRoleOwner || role == models.OrgRoleAdmin {
+		return "an"
+	}
+	return "a"
+}
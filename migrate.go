@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/anishsharma21/go-backend-starter-template/migrations"
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+commands:
+  up              apply all pending migrations
+  up-to VERSION   apply pending migrations up to and including VERSION
+  down            roll back the latest migration
+  redo            roll back the latest migration and apply it again
+  status          list every migration and whether it has been applied
+  create NAME     write a new empty SQL migration to the migrations directory`
+
+// migrationsDir is where `migrate create` writes new migrations, which are embedded from there into the binary
+var migrationsDir = "migrations"
+
+// migrationsFS holds the migrations the other commands and the schema check run against
+var migrationsFS fs.FS = migrations.FS
+
+// runMigrations applies all pending migrations, which is what the server does at startup when RUN_MIGRATION is true
+func runMigrations(ctx context.Context) error {
+	return runMigrateCommand(ctx, []string{"up"})
+}
+
+// runMigrateCommand runs a migrate subcommand against the database with the migrations embedded in the binary.
+// Migrations hold a Postgres advisory lock while they run, so replicas starting together apply them one at a time.
+func runMigrateCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n%s", migrateUsage)
+	}
+	command, args := args[0], args[1:]
+
+	// Creating a migration only writes a file, so it works without a database
+	if command == "create" {
+		if len(args) != 1 {
+			return fmt.Errorf("create takes the migration name\n%s", migrateUsage)
+		}
+		return goose.Create(nil, migrationsDir, args[0], "sql")
+	}
+
+	// Arguments are checked before connecting, so a mistyped command fails straight away
+	var version int64
+	switch command {
+	case "up", "down", "redo", "status":
+		if len(args) != 0 {
+			return fmt.Errorf("%s takes no arguments\n%s", command, migrateUsage)
+		}
+	case "up-to":
+		if len(args) != 1 {
+			return fmt.Errorf("up-to takes the version to migrate to\n%s", migrateUsage)
+		}
+		var err error
+		version, err = strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid migration version %q: %v", args[0], err)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", command, migrateUsage)
+	}
+
+	provider, db, err := newMigrationProvider()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch command {
+	case "up":
+		results, err := provider.Up(ctx)
+		logMigrationResults(results)
+		if err != nil {
+			return fmt.Errorf("Failed to apply migrations: %w", err)
+		}
+	case "up-to":
+		results, err := provider.UpTo(ctx, version)
+		logMigrationResults(results)
+		if err != nil {
+			return fmt.Errorf("Failed to apply migrations up to version %d: %w", version, err)
+		}
+	case "down":
+		result, err := provider.Down(ctx)
+		logMigrationResults([]*goose.MigrationResult{result})
+		if err != nil {
+			return fmt.Errorf("Failed to roll back migration: %w", err)
+		}
+	case "redo":
+		result, err := provider.Down(ctx)
+		logMigrationResults([]*goose.MigrationResult{result})
+		if err != nil {
+			return fmt.Errorf("Failed to roll back migration: %w", err)
+		}
+		version := result.Source.Version
+		result, err = provider.ApplyVersion(ctx, version, true)
+		logMigrationResults([]*goose.MigrationResult{result})
+		if err != nil {
+			return fmt.Errorf("Failed to reapply migration %d: %w", version, err)
+		}
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to retrieve status of migrations: %w", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Applied At\tMigration")
+		for _, status := range statuses {
+			appliedAt := "Pending"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\n", appliedAt, status.Source.Path)
+		}
+		return w.Flush()
+	}
+
+	dbVersion, err := provider.GetDBVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve database version: %w", err)
+	}
+	slog.Info("Database is at migration version", "version", dbVersion)
+
+	return nil
+}
+
+// checkSchemaVersion returns an error explaining the mismatch when the database has not applied exactly the migrations
+// embedded in the binary, as the queries would otherwise fail at runtime against tables or columns that differ
+func checkSchemaVersion(ctx context.Context) error {
+	provider, db, err := newMigrationProvider()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	current, target, err := provider.GetVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve database schema version: %w", err)
+	}
+
+	if current > target {
+		return fmt.Errorf("database schema is at version %d, which is newer than this build's latest migration %d, deploy a build that includes it or roll the database back with `migrate down`", current, target)
+	}
+
+	pending, err := provider.HasPending(ctx)
+	if err != nil {
+		return fmt.Errorf("database schema at version %d doesn't match this build's migrations: %w", current, err)
+	}
+	if pending {
+		return fmt.Errorf("database schema is at version %d but this build expects version %d, apply the pending migrations with `migrate up` or set RUN_MIGRATION=true", current, target)
+	}
+
+	return nil
+}
+
+// newMigrationProvider opens a database connection and returns a goose provider for the embedded migrations.
+// The caller must close the connection.
+func newMigrationProvider() (*goose.Provider, *sql.DB, error) {
+	db, err := sql.Open("postgres", dbConnStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to open database connection for *sql.DB: %v", err)
+	}
+
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("Failed to create migration lock: %v", err)
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrationsFS, goose.WithSessionLocker(locker))
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("Failed to load migrations: %v", err)
+	}
+
+	return provider, db, nil
+}
+
+func logMigrationResults(results []*goose.MigrationResult) {
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		if result.Error != nil {
+			slog.Error("Migration failed", "direction", result.Direction, "migration", result.Source.Path, "error", result.Error)
+			continue
+		}
+		slog.Info("Ran migration", "direction", result.Direction, "migration", result.Source.Path, "empty", result.Empty, "duration", result.Duration)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/anishsharma21/go-backend-starter-template/migrations"
+)
+
+func TestRunMigrateCommandArguments(t *testing.T) {
+	// Every one of these is refused before connecting to the database
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{args: nil, err: "missing migrate command"},
+		{args: []string{"sideways"}, err: `unknown migrate command "sideways"`},
+		{args: []string{"up", "now"}, err: "up takes no arguments"},
+		{args: []string{"status", "-v"}, err: "status takes no arguments"},
+		{args: []string{"up-to"}, err: "up-to takes the version to migrate to"},
+		{args: []string{"up-to", "1", "2"}, err: "up-to takes the version to migrate to"},
+		{args: []string{"up-to", "latest"}, err: `Invalid migration version "latest"`},
+		{args: []string{"create"}, err: "create takes the migration name"},
+		{args: []string{"create", "add", "widgets"}, err: "create takes the migration name"},
+	}
+
+	for _, tt := range tests {
+		err := runMigrateCommand(context.Background(), tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Expected error containing %q for %q, got %v\n", tt.err, tt.args, err)
+		}
+	}
+}
+
+func TestMigrateCreate(t *testing.T) {
+	dir := t.TempDir()
+	previous := migrationsDir
+	migrationsDir = dir
+	defer func() { migrationsDir = previous }()
+
+	if err := runMigrateCommand(context.Background(), []string{"create", "add_widgets"}); err != nil {
+		t.Fatalf("Expected no error creating a migration, got %v\n", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one migration file, got %v and %v\n", entries, err)
+	}
+	if name := entries[0].Name(); !regexp.MustCompile(`^\d{14}_add_widgets\.sql$`).MatchString(name) {
+		t.Errorf("Expected a timestamped migration named add_widgets, got %s\n", name)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("Failed to read migration: %v\n", err)
+	}
+	for _, annotation := range []string{"-- +goose Up", "-- +goose Down"} {
+		if !strings.Contains(string(content), annotation) {
+			t.Errorf("Expected the migration to contain %q, got %s\n", annotation, content)
+		}
+	}
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	previousConnStr, previousFS := dbConnStr, migrationsFS
+	dbConnStr = databaseURL
+	defer func() { dbConnStr, migrationsFS = previousConnStr, previousFS }()
+
+	// The test database has applied every embedded migration
+	if err := checkSchemaVersion(context.Background()); err != nil {
+		t.Fatalf("Expected the migrated test database to match, got %v\n", err)
+	}
+
+	names, err := fs.Glob(migrations.FS, "*.sql")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("Failed to list migrations: %v\n", err)
+	}
+	embedded := fstest.MapFS{}
+	for _, name := range names {
+		data, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			t.Fatalf("Failed to read migration %s: %v\n", name, err)
+		}
+		embedded[name] = &fstest.MapFile{Data: data}
+	}
+
+	// A build with a migration the database hasn't applied yet
+	pending := maps.Clone(embedded)
+	pending["99991231000000_pending.sql"] = &fstest.MapFile{Data: []byte("-- +goose Up\nSELECT 1;\n\n-- +goose Down\nSELECT 1;\n")}
+	migrationsFS = pending
+	if err = checkSchemaVersion(context.Background()); err == nil || !strings.Contains(err.Error(), "apply the pending migrations") {
+		t.Errorf("Expected a pending migration to be reported, got %v\n", err)
+	}
+
+	// An older build that doesn't know about the database's latest migration
+	older := maps.Clone(embedded)
+	delete(older, names[len(names)-1])
+	migrationsFS = older
+	if err = checkSchemaVersion(context.Background()); err == nil || !strings.Contains(err.Error(), "newer than this build's latest migration") {
+		t.Errorf("Expected a database ahead of the build to be reported, got %v\n", err)
+	}
+}
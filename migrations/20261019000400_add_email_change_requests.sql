@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tokens issued before tokens_valid_after are rejected, new users only accept tokens issued after they were created
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMPTZ;
+ALTER TABLE users ALTER COLUMN tokens_valid_after SET DEFAULT CURRENT_TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX email_change_requests_user_id_idx ON email_change_requests (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_change_requests;
+ALTER TABLE users DROP COLUMN tokens_valid_after;
+-- +goose StatementEnd
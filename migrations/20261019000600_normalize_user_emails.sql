@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Emails used to be stored HTML escaped and case sensitive. Normalize them the same way the application now does,
+-- refusing to migrate if that would merge two existing accounts so they can be resolved by hand first.
+CREATE TEMPORARY TABLE normalized_emails ON COMMIT DROP AS
+SELECT id, email, lower(trim(
+    replace(replace(replace(replace(replace(email, '&#39;', ''''), '&#34;', '"'), '&lt;', '<'), '&gt;', '>'), '&amp;', '&')
+)) AS normalized
+FROM users;
+
+DO $$
+DECLARE
+    collisions TEXT;
+BEGIN
+    SELECT string_agg(format('%s (user ids %s)', normalized, ids), '; ')
+    INTO collisions
+    FROM (
+        SELECT normalized, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM normalized_emails
+        GROUP BY normalized
+        HAVING count(*) > 1
+    ) c;
+
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'Cannot normalize user emails, these accounts collide: %', collisions
+            USING HINT = 'Merge or change the emails of the colliding accounts, then run the migration again';
+    END IF;
+END
+$$;
+
+UPDATE users u SET email = n.normalized
+FROM normalized_emails n
+WHERE u.id = n.id AND u.email <> n.normalized;
+
+ALTER TABLE users DROP CONSTRAINT email_unique;
+CREATE UNIQUE INDEX email_unique ON users (lower(email));
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS email_unique;
+ALTER TABLE users ADD CONSTRAINT email_unique UNIQUE (email);
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- Every change to a user bumps its version, so ETags change even when a row is updated outside the user update endpoint
+CREATE FUNCTION bump_user_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_bump_version
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION bump_user_version();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER users_bump_version ON users;
+DROP FUNCTION bump_user_version();
+ALTER TABLE users DROP COLUMN version;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX users_attributes_idx ON users USING GIN (attributes jsonb_path_ops);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX users_attributes_idx;
+ALTER TABLE users DROP COLUMN attributes;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(63) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT organizations_slug_unique UNIQUE (slug)
+);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    organization_id INT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, user_id)
+);
+CREATE INDEX memberships_user_id_idx ON memberships (user_id);
+
+-- The organization a session is working in, so refreshed access tokens keep the organization the user switched to
+ALTER TABLE sessions ADD COLUMN organization_id INT REFERENCES organizations(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE sessions DROP COLUMN organization_id;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
+-- +goose StatementEnd
@@ -0,0 +1,73 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Organization scoped queries switch to this role with SET LOCAL ROLE, so the policies below apply to them even though
+-- the application connects as the owner of the tables, which row level security never applies to
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'app_tenant') THEN
+        CREATE ROLE app_tenant NOLOGIN;
+    END IF;
+END
+$$;
+GRANT app_tenant TO CURRENT_USER;
+
+-- The organization and user of the current transaction, set with set_config(..., true) by the queries layer.
+-- Once a transaction that set them ends they read as an empty string rather than NULL, so both cases mean unset.
+CREATE FUNCTION app_current_org_id() RETURNS INT AS $$
+    SELECT NULLIF(current_setting('app.current_org_id', true), '')::INT
+$$ LANGUAGE sql STABLE;
+
+CREATE FUNCTION app_current_user_id() RETURNS INT AS $$
+    SELECT NULLIF(current_setting('app.current_user_id', true), '')::INT
+$$ LANGUAGE sql STABLE;
+
+GRANT USAGE ON SCHEMA public TO app_tenant;
+GRANT SELECT ON organizations TO app_tenant;
+GRANT SELECT, INSERT, UPDATE, DELETE ON memberships, invitations TO app_tenant;
+GRANT USAGE ON SEQUENCE invitations_id_seq TO app_tenant;
+GRANT SELECT ON users TO app_tenant;
+GRANT INSERT ON audit_events TO app_tenant;
+GRANT USAGE ON SEQUENCE audit_events_id_seq TO app_tenant;
+
+ALTER TABLE organizations ENABLE ROW LEVEL SECURITY;
+CREATE POLICY organizations_tenant_isolation ON organizations
+    USING (id = app_current_org_id());
+
+ALTER TABLE memberships ENABLE ROW LEVEL SECURITY;
+CREATE POLICY memberships_tenant_isolation ON memberships
+    USING (organization_id = app_current_org_id())
+    WITH CHECK (organization_id = app_current_org_id());
+
+ALTER TABLE invitations ENABLE ROW LEVEL SECURITY;
+CREATE POLICY invitations_tenant_isolation ON invitations
+    USING (organization_id = app_current_org_id())
+    WITH CHECK (organization_id = app_current_org_id());
+
+-- Users are shared between organizations, so a tenant can see the current user and the members of its organization
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+CREATE POLICY users_tenant_isolation ON users FOR SELECT
+    USING (
+        id = app_current_user_id()
+        OR EXISTS (SELECT 1 FROM memberships m WHERE m.user_id = users.id AND m.organization_id = app_current_org_id())
+    );
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP POLICY users_tenant_isolation ON users;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+DROP POLICY invitations_tenant_isolation ON invitations;
+ALTER TABLE invitations DISABLE ROW LEVEL SECURITY;
+DROP POLICY memberships_tenant_isolation ON memberships;
+ALTER TABLE memberships DISABLE ROW LEVEL SECURITY;
+DROP POLICY organizations_tenant_isolation ON organizations;
+ALTER TABLE organizations DISABLE ROW LEVEL SECURITY;
+
+REVOKE ALL ON organizations, memberships, invitations, users, audit_events FROM app_tenant;
+REVOKE ALL ON SEQUENCE invitations_id_seq, audit_events_id_seq FROM app_tenant;
+REVOKE USAGE ON SCHEMA public FROM app_tenant;
+
+DROP FUNCTION app_current_user_id();
+DROP FUNCTION app_current_org_id();
+-- The role is shared by every database in the cluster, so it is left in place for any others still using it
+-- +goose StatementEnd
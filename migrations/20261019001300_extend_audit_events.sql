@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE audit_events ADD COLUMN ip_address VARCHAR(64);
+ALTER TABLE audit_events ADD COLUMN request_id VARCHAR(64);
+ALTER TABLE audit_events ADD COLUMN before JSONB;
+ALTER TABLE audit_events ADD COLUMN after JSONB;
+
+CREATE INDEX audit_events_action_idx ON audit_events (action);
+CREATE INDEX audit_events_actor_email_idx ON audit_events (actor_email);
+CREATE INDEX audit_events_target_idx ON audit_events (target);
+CREATE INDEX audit_events_request_id_idx ON audit_events (request_id);
+
+-- Audit events can only be added. The only exceptions are the retention purge and anonymizing erased users,
+-- which have to opt in by setting app.audit_maintenance for their transaction.
+CREATE FUNCTION prevent_audit_event_changes() RETURNS TRIGGER AS $$
+BEGIN
+    IF current_setting('app.audit_maintenance', true) = 'on' THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW
+    EXECUTE FUNCTION prevent_audit_event_changes();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION prevent_audit_event_changes();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER audit_events_no_truncate ON audit_events;
+DROP TRIGGER audit_events_append_only ON audit_events;
+DROP FUNCTION prevent_audit_event_changes();
+
+DROP INDEX audit_events_request_id_idx;
+DROP INDEX audit_events_target_idx;
+DROP INDEX audit_events_actor_email_idx;
+DROP INDEX audit_events_action_idx;
+
+ALTER TABLE audit_events DROP COLUMN after;
+ALTER TABLE audit_events DROP COLUMN before;
+ALTER TABLE audit_events DROP COLUMN request_id;
+ALTER TABLE audit_events DROP COLUMN ip_address;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Domain events for other systems, written in the same transaction as the change they describe and delivered
+-- afterwards by the outbox relay, so an event is never lost or sent for a change that rolled back
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    aggregate_type VARCHAR(50) NOT NULL,
+    aggregate_id VARCHAR(100) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMPTZ,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT
+);
+CREATE INDEX outbox_events_pending_idx ON outbox_events (id) WHERE published_at IS NULL;
+CREATE INDEX outbox_events_aggregate_idx ON outbox_events (aggregate_type, aggregate_id, id) WHERE published_at IS NULL;
+CREATE INDEX outbox_events_published_at_idx ON outbox_events (published_at) WHERE published_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS outbox_events;
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Background jobs, claimed by workers with FOR UPDATE SKIP LOCKED. Finished jobs are deleted, and jobs that used up
+-- their attempts are kept as dead for someone to look into.
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    args JSONB NOT NULL DEFAULT '{}',
+    state VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'running', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL DEFAULT 5,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    unique_key VARCHAR(255),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_at TIMESTAMPTZ
+);
+CREATE INDEX jobs_pending_idx ON jobs (run_at, id) WHERE state = 'pending';
+CREATE INDEX jobs_running_idx ON jobs (locked_at) WHERE state = 'running';
+-- A unique job can't be queued again while a job with the same kind and key is waiting or running
+CREATE UNIQUE INDEX jobs_unique_key_idx ON jobs (kind, unique_key) WHERE unique_key IS NOT NULL AND state IN ('pending', 'running');
+
+-- Data exports used to be picked up by polling their own table, queue jobs for the ones that haven't finished
+INSERT INTO jobs (kind, args, unique_key)
+SELECT 'data_exports.generate', jsonb_build_object('data_export_id', id), id::text
+FROM data_exports WHERE status IN ('pending', 'processing');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS jobs;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The latest run of each scheduled task. Claiming a run moves scheduled_for forward, so a run is never started twice
+-- even if two instances briefly both think they are the scheduler leader.
+CREATE TABLE IF NOT EXISTS scheduled_tasks (
+    name VARCHAR(100) PRIMARY KEY,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('running', 'succeeded', 'failed')),
+    error TEXT,
+    instance VARCHAR(255) NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS scheduled_tasks;
+-- +goose StatementEnd
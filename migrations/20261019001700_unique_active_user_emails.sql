@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Soft deleted users no longer hold on to their email, so the address can be used for a new account while the old
+-- one waits to be purged. Restoring the old account fails if its email has been taken in the meantime.
+DROP INDEX email_unique;
+CREATE UNIQUE INDEX email_unique ON users (lower(email)) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX email_unique;
+CREATE UNIQUE INDEX email_unique ON users (lower(email));
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Organization scoped transactions queue their emails, such as invitations, in the same transaction as the change
+-- Queueing a job reads the columns of the unique key index it checks for an existing job
+GRANT INSERT ON jobs TO app_tenant;
+GRANT SELECT (kind, unique_key, state) ON jobs TO app_tenant;
+GRANT USAGE ON SEQUENCE jobs_id_seq TO app_tenant;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+REVOKE ALL ON jobs FROM app_tenant;
+REVOKE ALL ON SEQUENCE jobs_id_seq FROM app_tenant;
+-- +goose StatementEnd
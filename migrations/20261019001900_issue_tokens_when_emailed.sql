@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Email change confirmations and invitations are emailed by a job that only has the id of the request, and issues a
+-- token as it sends the email, so a token is never stored anywhere but in the email. Until then there is no token.
+ALTER TABLE email_change_requests ALTER COLUMN token_hash DROP NOT NULL;
+ALTER TABLE invitations ALTER COLUMN token_hash DROP NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM email_change_requests WHERE token_hash IS NULL;
+DELETE FROM invitations WHERE token_hash IS NULL;
+ALTER TABLE email_change_requests ALTER COLUMN token_hash SET NOT NULL;
+ALTER TABLE invitations ALTER COLUMN token_hash SET NOT NULL;
+-- +goose StatementEnd
@@ -0,0 +1,104 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The application used to own audit_events, so it could set app.audit_maintenance, drop the append only trigger or
+-- grant itself back any privilege. The table now belongs to audit_maintainer, a role nobody logs in as, and the
+-- application can only read and add events. The retention purge and the anonymization of erased users run as that
+-- role through the two SECURITY DEFINER functions below, which are the only ways left to change events.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'audit_maintainer') THEN
+        CREATE ROLE audit_maintainer NOLOGIN;
+    END IF;
+END
+$$;
+
+-- Membership is only needed to hand the table and functions over, and is given up again at the end
+GRANT audit_maintainer TO CURRENT_USER;
+
+ALTER TABLE audit_events OWNER TO audit_maintainer;
+GRANT SELECT, INSERT ON audit_events TO CURRENT_USER;
+GRANT USAGE ON SEQUENCE audit_events_id_seq TO CURRENT_USER;
+
+-- Events can only be changed by the functions below, even by superusers, who skip privilege checks
+CREATE OR REPLACE FUNCTION prevent_audit_event_changes() RETURNS TRIGGER AS $$
+BEGIN
+    IF current_user = 'audit_maintainer' AND TG_OP <> 'TRUNCATE' THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+ALTER FUNCTION prevent_audit_event_changes() OWNER TO audit_maintainer;
+
+-- Removes events older than the retention period, which can't be shorter than a day, and returns how many it removed
+CREATE FUNCTION purge_audit_events(retention INTERVAL) RETURNS BIGINT
+LANGUAGE plpgsql SECURITY DEFINER SET search_path = public, pg_temp AS $$
+DECLARE
+    purged BIGINT;
+BEGIN
+    IF retention < INTERVAL '1 day' THEN
+        RAISE EXCEPTION 'audit events must be kept for at least a day, got %', retention;
+    END IF;
+
+    DELETE FROM audit_events WHERE created_at < CURRENT_TIMESTAMP - retention;
+    GET DIAGNOSTICS purged = ROW_COUNT;
+    RETURN purged;
+END;
+$$;
+
+-- Replaces the erased user's emails with a pseudonym and removes their personal data from the events they made, were
+-- the target of or that mention their email
+CREATE FUNCTION anonymize_erased_user_audit_events(erased_user_id INT, emails TEXT[]) RETURNS VOID
+LANGUAGE plpgsql SECURITY DEFINER SET search_path = public, pg_temp AS $$
+DECLARE
+    erased_target TEXT := 'user:' || erased_user_id;
+    pseudonym TEXT := 'erased-user-' || erased_user_id;
+BEGIN
+    UPDATE audit_events SET
+        actor_email = CASE WHEN actor_email = ANY(emails) OR target = erased_target THEN pseudonym ELSE actor_email END,
+        ip_address = CASE WHEN actor_email = ANY(emails) THEN NULL ELSE ip_address END,
+        details = details - 'old_email' - 'new_email' - CASE WHEN details->>'email' = ANY(emails) THEN 'email' ELSE '' END,
+        before = before - 'email' - 'first_name' - 'last_name' - 'avatar_url' - 'attributes',
+        after = after - 'email' - 'first_name' - 'last_name' - 'avatar_url' - 'attributes'
+    WHERE actor_email = ANY(emails) OR target = erased_target OR details->>'email' = ANY(emails);
+END;
+$$;
+
+ALTER FUNCTION purge_audit_events(INTERVAL) OWNER TO audit_maintainer;
+ALTER FUNCTION anonymize_erased_user_audit_events(INT, TEXT[]) OWNER TO audit_maintainer;
+REVOKE EXECUTE ON FUNCTION purge_audit_events(INTERVAL), anonymize_erased_user_audit_events(INT, TEXT[]) FROM PUBLIC;
+GRANT EXECUTE ON FUNCTION purge_audit_events(INTERVAL), anonymize_erased_user_audit_events(INT, TEXT[]) TO CURRENT_USER;
+
+REVOKE audit_maintainer FROM CURRENT_USER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+GRANT audit_maintainer TO CURRENT_USER;
+
+DROP FUNCTION anonymize_erased_user_audit_events(INT, TEXT[]);
+DROP FUNCTION purge_audit_events(INTERVAL);
+
+ALTER FUNCTION prevent_audit_event_changes() OWNER TO CURRENT_USER;
+CREATE OR REPLACE FUNCTION prevent_audit_event_changes() RETURNS TRIGGER AS $$
+BEGIN
+    IF current_setting('app.audit_maintenance', true) = 'on' THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'audit_events is append only, % is not allowed', TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE audit_events OWNER TO CURRENT_USER;
+
+REVOKE audit_maintainer FROM CURRENT_USER;
+-- The role is shared by every database in the cluster, so it is left in place for any others still using it
+-- +goose StatementEnd
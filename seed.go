@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/attributes"
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/seed"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// runSeedCommand applies a fixture set from the fixtures directory, the one named after ENV unless another is given
+func runSeedCommand(ctx context.Context, dbPool *pgxpool.Pool, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: main seed [fixture set]")
+	}
+
+	set := env
+	if set == "" {
+		set = "development"
+	}
+	if len(args) == 1 {
+		set = args[0]
+	}
+
+	if err := checkSchemaVersion(ctx); err != nil {
+		return err
+	}
+
+	fixtures, err := seed.Load(os.DirFS("fixtures"), set)
+	if err != nil {
+		return err
+	}
+
+	registry, err := attributes.LoadRegistryFromEnv()
+	if err != nil {
+		return err
+	}
+
+	store := repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0))
+	return seed.Apply(ctx, seed.Target{Users: store, DB: dbPool, Registry: registry}, fixtures)
+}
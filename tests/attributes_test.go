@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/attributes"
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+)
+
+func newTestRegistry(t *testing.T) *attributes.Registry {
+	t.Helper()
+
+	minEmployeeNumber := 1.0
+	registry, err := attributes.NewRegistry(map[string]attributes.Definition{
+		"department":      {Type: attributes.TypeString, Required: true, Enum: []string{"engineering", "sales"}},
+		"employee_number": {Type: attributes.TypeInteger, Min: &minEmployeeNumber},
+		"start_date":      {Type: attributes.TypeDate},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create registry: %v\n", err)
+	}
+
+	return registry
+}
+
+func TestAttributeRegistryApply(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	merged, err := registry.Apply(
+		map[string]any{"department": "sales", "start_date": "2024-01-02"},
+		map[string]any{"employee_number": 42.0, "start_date": nil},
+	)
+	if err != nil {
+		t.Fatalf("Expected no error applying a valid patch, got %v\n", err)
+	}
+	if merged["employee_number"] != int64(42) {
+		t.Errorf("Expected employee_number to be stored as an integer, got %#v\n", merged["employee_number"])
+	}
+	if _, ok := merged["start_date"]; ok {
+		t.Errorf("Expected start_date to be removed by a null value\n")
+	}
+
+	invalid := []map[string]any{
+		{"department": "marketing"},
+		{"department": "sales", "employee_number": 1.5},
+		{"department": "sales", "employee_number": 0.0},
+		{"department": "sales", "start_date": "02/01/2024"},
+		{"department": "sales", "unknown": "value"},
+		{"employee_number": 7.0},
+	}
+	for _, patch := range invalid {
+		if _, err := registry.Apply(nil, patch); err == nil {
+			t.Errorf("Expected an error applying %v\n", patch)
+		}
+	}
+}
+
+func TestAttributeRegistryParseFilterValue(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	value, err := registry.ParseFilterValue("employee_number", "42")
+	if err != nil || value != int64(42) {
+		t.Errorf("Expected employee_number filter to parse as 42, got %#v and %v\n", value, err)
+	}
+
+	if _, err := registry.ParseFilterValue("employee_number", "abc"); err == nil {
+		t.Errorf("Expected an error parsing a non numeric employee_number filter\n")
+	}
+	if _, err := registry.ParseFilterValue("unknown", "value"); err == nil {
+		t.Errorf("Expected an error filtering on an undeclared attribute\n")
+	}
+}
+
+func TestRequiredAttributesAreOnlyEnforcedOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	handler := handlers.UpdateUser(store, newTestRegistry(t))
+
+	// Users are created without the required department
+	id, err := store.SignUpNewUser(ctx, newTestUser("required@example.com"))
+	if err != nil {
+		t.Fatalf("Expected signing up without required attributes to succeed, got %v\n", err)
+	}
+
+	update := func(body string, wantStatus int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%d", id), strings.NewReader(body))
+		req.SetPathValue("id", fmt.Sprint(id))
+		req.Header.Set("If-Match", "*")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != wantStatus {
+			t.Fatalf("Expected status code %d for %s, got %d: %s\n", wantStatus, body, resp.Code, resp.Body.String())
+		}
+	}
+
+	update(`{"first_name": "Renamed"}`, http.StatusOK)
+	update(`{"attributes": {"employee_number": 7}}`, http.StatusUnprocessableEntity)
+	update(`{"attributes": {"department": "sales", "employee_number": 7}}`, http.StatusOK)
+	update(`{"attributes": {"department": null}}`, http.StatusUnprocessableEntity)
+
+	user, err := store.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v\n", err)
+	}
+	if user.Attributes["department"] != "sales" || *user.FirstName != "Renamed" {
+		t.Errorf("Expected the valid updates to be stored, got %v and %s\n", user.Attributes, *user.FirstName)
+	}
+}
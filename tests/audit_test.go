@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/audit"
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestAuditDiff(t *testing.T) {
+	type record struct {
+		Name  string `json:"name"`
+		Role  string `json:"role"`
+		Count int    `json:"count"`
+	}
+
+	before, after, err := audit.Diff(
+		record{Name: "Ada", Role: "user", Count: 1},
+		record{Name: "Ada", Role: "admin", Count: 2},
+	)
+	if err != nil {
+		t.Fatalf("Failed to diff records: %v\n", err)
+	}
+
+	wantBefore := map[string]any{"role": "user", "count": 1.0}
+	wantAfter := map[string]any{"role": "admin", "count": 2.0}
+	if !reflect.DeepEqual(before, wantBefore) {
+		t.Errorf("Expected before %v, got %v\n", wantBefore, before)
+	}
+	if !reflect.DeepEqual(after, wantAfter) {
+		t.Errorf("Expected after %v, got %v\n", wantAfter, after)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var metadata audit.Metadata
+	handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata = audit.MetadataFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		remoteAddr, header, ipAddress string
+		reused                        bool
+	}{
+		{remoteAddr: "203.0.113.7:51234", header: "abc-123.x_y", ipAddress: "203.0.113.7", reused: true},
+		{remoteAddr: "[2001:db8::1]:443", header: "", ipAddress: "2001:db8::1"},
+		{remoteAddr: "unix-socket", header: "not a valid id!", ipAddress: "unix-socket"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Request-ID", tt.header)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		requestID := resp.Header().Get("X-Request-ID")
+		if tt.reused && requestID != tt.header || !tt.reused && len(requestID) != 32 {
+			t.Errorf("Expected request id for header %q to be reused %t, got %q\n", tt.header, tt.reused, requestID)
+		}
+		if metadata.RequestID != requestID || metadata.IPAddress != tt.ipAddress {
+			t.Errorf("Expected audit metadata %q and %q, got %q and %q\n", requestID, tt.ipAddress, metadata.RequestID, metadata.IPAddress)
+		}
+		if ip := middleware.ClientIP(req); ip != tt.ipAddress {
+			t.Errorf("Expected client IP %q, got %q\n", tt.ipAddress, ip)
+		}
+	}
+}
+
+// Audit events can only be changed through the maintenance functions, whatever the application sets for its transaction
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	actor := uniqueEmail()
+
+	err := queries.WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error {
+		return queries.InsertAuditEvent(ctx, tx, models.AuditEvent{ActorEmail: &actor, Action: "tests.append_only"})
+	})
+	if err != nil {
+		t.Fatalf("Failed to record audit event: %v\n", err)
+	}
+
+	err = queries.WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT set_config('app.audit_maintenance', 'on', true)`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE audit_events SET action = 'tests.changed' WHERE actor_email = $1`, actor)
+		return err
+	})
+	if err == nil {
+		t.Errorf("Expected updating an audit event to fail\n")
+	}
+
+	if _, err = queries.PurgeAuditEvents(ctx, dbPool, time.Hour); err == nil {
+		t.Errorf("Expected purging audit events kept for less than a day to fail\n")
+	}
+
+	var action string
+	err = dbPool.QueryRow(ctx, `SELECT action FROM audit_events WHERE actor_email = $1`, actor).Scan(&action)
+	if err != nil || action != "tests.append_only" {
+		t.Errorf("Expected the audit event to be unchanged, got %q and %v\n", action, err)
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/anishsharma21/go-backend-starter-template/internal/workers"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestDataExportArchive(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	email := uniqueEmail()
+	cleanupUsers(t, repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0)), email)
+
+	userID, err := queries.SignUpNewUser(ctx, dbPool, newTestUser(email))
+	if err != nil {
+		t.Fatalf("Failed to sign up user: %v\n", err)
+	}
+
+	dataExport, err := queries.CreateDataExport(ctx, dbPool, userID)
+	if err != nil {
+		t.Fatalf("Failed to create data export: %v\n", err)
+	}
+	t.Cleanup(func() {
+		dbPool.Exec(context.Background(), `DELETE FROM jobs WHERE kind = 'data_exports.generate' AND (args->>'data_export_id')::bigint = $1`, dataExport.ID)
+	})
+
+	archive, err := workers.BuildDataExportArchive(ctx, dbPool, userID)
+	if err != nil {
+		t.Fatalf("Failed to build archive: %v\n", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("Expected a zip archive, got %v\n", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		if f.Name != "profile.json" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open profile.json: %v\n", err)
+		}
+		profile, _ := io.ReadAll(rc)
+		rc.Close()
+		if !strings.Contains(string(profile), email) || strings.Contains(string(profile), "not-a-hash") {
+			t.Errorf("Expected the profile to hold the user's email and not their password hash, got %s\n", profile)
+		}
+	}
+	if strings.Join(names, ",") != "profile.json,sessions.json,audit_events.json,data_exports.json" {
+		t.Errorf("Expected one file per kind of data, got %v\n", names)
+	}
+}
+
+func TestScheduledUserErasure(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	email := uniqueEmail()
+	cleanupUsers(t, repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0)), email)
+
+	userID, err := queries.SignUpNewUser(ctx, dbPool, newTestUser(email))
+	if err != nil {
+		t.Fatalf("Failed to sign up user: %v\n", err)
+	}
+
+	// A cancelled erasure doesn't happen, and can't be cancelled twice
+	if _, err = queries.ScheduleUserErasure(ctx, dbPool, userID, time.Hour); err != nil {
+		t.Fatalf("Failed to schedule erasure: %v\n", err)
+	}
+	if err = queries.CancelUserErasure(ctx, dbPool, userID); err != nil {
+		t.Fatalf("Failed to cancel erasure: %v\n", err)
+	}
+	if err = queries.CancelUserErasure(ctx, dbPool, userID); !errors.Is(err, queries.ErrUserNotFound) {
+		t.Errorf("Expected cancelling without a scheduled erasure to fail with %q, got %v\n", queries.ErrUserNotFound, err)
+	}
+
+	// Scheduling again doesn't push back an erasure that is already scheduled
+	first, err := queries.ScheduleUserErasure(ctx, dbPool, userID, 0)
+	if err != nil {
+		t.Fatalf("Failed to schedule erasure: %v\n", err)
+	}
+	second, err := queries.ScheduleUserErasure(ctx, dbPool, userID, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to schedule erasure again: %v\n", err)
+	}
+	if !second.Equal(first) {
+		t.Errorf("Expected the erasure to stay scheduled at %v, got %v\n", first, second)
+	}
+
+	if _, _, err = queries.EraseScheduledUsers(ctx, dbPool); err != nil {
+		t.Fatalf("Failed to erase scheduled users: %v\n", err)
+	}
+	if _, err = queries.GetUserByID(ctx, dbPool, userID); !errors.Is(err, queries.ErrUserNotFound) {
+		t.Errorf("Expected the user to be erased once the cooling off period ended, got %v\n", err)
+	}
+
+	events := userOutboxEvents(t, dbPool, userID)
+	if last := events[len(events)-1]; last.EventType != queries.UserDeletedEvent || last.Payload["permanent"] != true {
+		t.Errorf("Expected erasure to publish a permanent users.deleted event, got %s with %v\n", last.EventType, last.Payload)
+	}
+}
+
+// Erasure removes the user's emails from everywhere they are kept, including under the email they had before changing it
+func TestUserErasureRemovesPersonalData(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	oldEmail, newEmail := uniqueEmail(), uniqueEmail()
+	cleanupUsers(t, repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0)), oldEmail, newEmail)
+
+	userID, err := queries.SignUpNewUser(ctx, dbPool, newTestUser(oldEmail))
+	if err != nil {
+		t.Fatalf("Failed to sign up user: %v\n", err)
+	}
+	err = queries.WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error {
+		err := queries.InsertAuditEvent(ctx, tx, models.AuditEvent{ActorEmail: &oldEmail, Action: "tests.erasure"})
+		if err != nil {
+			return err
+		}
+		inviter := "inviter-" + oldEmail
+		return queries.InsertAuditEvent(ctx, tx, models.AuditEvent{ActorEmail: &inviter, Action: "tests.erasure", Details: map[string]any{"email": oldEmail}})
+	})
+	if err != nil {
+		t.Fatalf("Failed to record audit event: %v\n", err)
+	}
+
+	if err = queries.CreateEmailChangeRequest(ctx, dbPool, userID, newEmail, time.Hour); err != nil {
+		t.Fatalf("Failed to request email change: %v\n", err)
+	}
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM jobs WHERE kind = $1 AND args->>'to' IN ($2, $3)`, models.SendEmailJob{}.Kind(), oldEmail, newEmail); err != nil {
+			t.Errorf("Failed to clean up jobs: %v\n", err)
+		}
+	})
+	var requestID int64
+	if err = dbPool.QueryRow(ctx, `SELECT id FROM email_change_requests WHERE user_id = $1`, userID).Scan(&requestID); err != nil {
+		t.Fatalf("Failed to get email change request: %v\n", err)
+	}
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM jobs WHERE kind = $1 AND (args->>'email_change_request_id')::bigint = $2`, models.EmailChangeConfirmationJob{}.Kind(), requestID); err != nil {
+			t.Errorf("Failed to clean up jobs: %v\n", err)
+		}
+	})
+	if _, err = queries.IssueEmailChangeToken(ctx, dbPool, requestID, "erasure-"+newEmail); err != nil {
+		t.Fatalf("Failed to issue email change token: %v\n", err)
+	}
+	if _, _, err = queries.ConfirmEmailChange(ctx, dbPool, "erasure-"+newEmail); err != nil {
+		t.Fatalf("Failed to confirm email change: %v\n", err)
+	}
+
+	// The notification of the change to the old address hasn't been sent yet
+	notification := models.SendEmailJob{To: oldEmail, Subject: "Your email address was changed", Body: "It is now " + newEmail}
+	if _, err = queries.EnqueueJob(ctx, dbPool, notification, queries.JobOptions{RunAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to enqueue job: %v\n", err)
+	}
+
+	if _, err = queries.ScheduleUserErasure(ctx, dbPool, userID, 0); err != nil {
+		t.Fatalf("Failed to schedule erasure: %v\n", err)
+	}
+	if _, _, err = queries.EraseScheduledUsers(ctx, dbPool); err != nil {
+		t.Fatalf("Failed to erase scheduled users: %v\n", err)
+	}
+
+	var auditEvents, jobs int
+	err = dbPool.QueryRow(ctx, `
+		SELECT count(*) FROM audit_events
+		WHERE actor_email IN ($1, $2) OR strpos(before::text || after::text || details::text, $1) > 0 OR strpos(before::text || after::text || details::text, $2) > 0`,
+		oldEmail, newEmail).Scan(&auditEvents)
+	if err != nil || auditEvents != 0 {
+		t.Errorf("Expected no audit events to mention either email, got %d and %v\n", auditEvents, err)
+	}
+	err = dbPool.QueryRow(ctx, `SELECT count(*) FROM jobs WHERE strpos(args::text, $1) > 0 OR strpos(args::text, $2) > 0`, oldEmail, newEmail).Scan(&jobs)
+	if err != nil || jobs != 0 {
+		t.Errorf("Expected no jobs to mention either email, got %d and %v\n", jobs, err)
+	}
+	for _, event := range userOutboxEvents(t, dbPool, userID) {
+		if payload, _ := json.Marshal(event.Payload); strings.Contains(string(payload), oldEmail) || strings.Contains(string(payload), newEmail) {
+			t.Errorf("Expected the %s event not to mention either email, got %s\n", event.EventType, payload)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDBPool connects to the migrated database at DATABASE_URL, as set up in CI, and skips the test when it isn't set
+func testDBPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+
+	dbPool, err := pgxpool.New(context.Background(), databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to the test database: %v\n", err)
+	}
+	t.Cleanup(dbPool.Close)
+
+	return dbPool
+}
+
+var testEmailCounter atomic.Int64
+
+// uniqueEmail returns an email no other test run uses, so tests can share the database
+func uniqueEmail() string {
+	return fmt.Sprintf("test-%d-%d@example.com", time.Now().UnixNano(), testEmailCounter.Add(1))
+}
+
+// createTestOrganization creates an organization owned by the user, which is removed once the test is done
+func createTestOrganization(t *testing.T, dbPool *pgxpool.Pool, owner models.User) models.Organization {
+	t.Helper()
+
+	slug := fmt.Sprintf("test-%d-%d", time.Now().UnixNano(), testEmailCounter.Add(1))
+	organization, err := queries.CreateOrganization(context.Background(), dbPool, "Test Organization", slug, owner)
+	if err != nil {
+		t.Fatalf("Failed to create organization: %v\n", err)
+	}
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM organizations WHERE id = $1`, organization.ID); err != nil {
+			t.Errorf("Failed to clean up organization %d: %v\n", organization.ID, err)
+		}
+	})
+
+	return organization
+}
@@ -0,0 +1,37 @@
+package tests
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+)
+
+func TestConfirmEmailChangeView(t *testing.T) {
+	templates, err := template.ParseFS(os.DirFS("../templates"), "*.html")
+	if err != nil {
+		t.Fatalf("Failed to parse templates: %v\n", err)
+	}
+	handler := handlers.ConfirmEmailChangeView(templates)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/email/confirm?token=abc%22%3E", nil))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d\n", resp.Code)
+	}
+	body := resp.Body.String()
+	// Opening the link only shows a form, which posts the escaped token when submitted
+	if !strings.Contains(body, `hx-post="/email/confirm"`) || !strings.Contains(body, `name="token" value="abc&#34;&gt;"`) {
+		t.Errorf("Expected a form posting the escaped token, got %s\n", body)
+	}
+
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/email/confirm", nil))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 without a token, got %d\n", resp.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestQueryErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{queries.ErrUserNotFound, queries.ErrNotFound},
+		{queries.ErrMembershipNotFound, queries.ErrNotFound},
+		{fmt.Errorf("failed to accept invitation: %w", queries.ErrInvitationNotFound), queries.ErrNotFound},
+		{queries.ErrEmailTaken, queries.ErrConflict},
+		{queries.ErrLastOwner, queries.ErrConflict},
+	}
+
+	for _, test := range tests {
+		if !errors.Is(test.err, test.kind) {
+			t.Errorf("Expected %q to match %q\n", test.err, test.kind)
+		}
+	}
+
+	if errors.Is(queries.ErrUserNotFound, queries.ErrConflict) {
+		t.Errorf("Expected %q not to match %q\n", queries.ErrUserNotFound, queries.ErrConflict)
+	}
+	if queries.ErrUserNotFound.Error() != "user not found" {
+		t.Errorf("Expected message %q, got %q\n", "user not found", queries.ErrUserNotFound.Error())
+	}
+}
+
+func TestMapConstraintError(t *testing.T) {
+	tests := []struct {
+		err        *pgconn.PgError
+		field      string
+		constraint string
+		conflict   bool
+	}{
+		{err: &pgconn.PgError{Code: "23505", ConstraintName: "email_unique", TableName: "users"}, field: "email", constraint: "email_unique", conflict: true},
+		{err: &pgconn.PgError{Code: "23505", ConstraintName: "memberships_pkey", TableName: "memberships"}, field: "pkey", constraint: "memberships_pkey", conflict: true},
+		{err: &pgconn.PgError{Code: "23514", ConstraintName: "invitations_role_check", TableName: "invitations"}, field: "role", constraint: "invitations_role_check"},
+		{err: &pgconn.PgError{Code: "23503", ConstraintName: "memberships_user_id_fkey", TableName: "memberships"}, field: "user_id", constraint: "memberships_user_id_fkey"},
+		{err: &pgconn.PgError{Code: "22001", ColumnName: "first_name"}, field: "first_name"},
+	}
+
+	for _, tt := range tests {
+		err := queries.MapConstraintError(fmt.Errorf("failed to write: %w", tt.err))
+
+		var constraintErr *queries.ErrConstraint
+		if !errors.As(err, &constraintErr) {
+			t.Fatalf("Expected an ErrConstraint for code %s, got %v\n", tt.err.Code, err)
+		}
+		if constraintErr.Field != tt.field || constraintErr.Constraint != tt.constraint {
+			t.Errorf("Expected field %q and constraint %q for code %s, got %q and %q\n", tt.field, tt.constraint, tt.err.Code, constraintErr.Field, constraintErr.Constraint)
+		}
+		if errors.Is(err, queries.ErrConflict) != tt.conflict {
+			t.Errorf("Expected code %s to match ErrConflict %t\n", tt.err.Code, tt.conflict)
+		}
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr != tt.err {
+			t.Errorf("Expected the Postgres error to still be reachable for code %s\n", tt.err.Code)
+		}
+		if mapped := queries.MapConstraintError(err); mapped != err {
+			t.Errorf("Expected mapping an ErrConstraint again to return it unchanged\n")
+		}
+	}
+
+	for _, err := range []error{&pgconn.PgError{Code: "40001"}, queries.ErrUserNotFound, errors.New("connection refused")} {
+		if mapped := queries.MapConstraintError(err); mapped != err {
+			t.Errorf("Expected %v to be returned unchanged, got %v\n", err, mapped)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+func TestExportUsers(t *testing.T) {
+	store := repository.NewMemory()
+	// More users than are written between flushes
+	const userCount = 501
+	for i := range userCount {
+		user := newTestUser(fmt.Sprintf("user%d@example.com", i))
+		comma := "Smith, Jr"
+		user.LastName = &comma
+		if _, err := store.SignUpNewUser(context.Background(), user); err != nil {
+			t.Fatalf("Failed to sign up user: %v\n", err)
+		}
+	}
+	handler := handlers.ExportUsers(store, newTestRegistry(t))
+
+	export := func(query string, wantStatus int) *httptest.ResponseRecorder {
+		t.Helper()
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/users/export?"+query, nil))
+		if resp.Code != wantStatus {
+			t.Fatalf("Expected status code %d exporting with %q, got %d: %s\n", wantStatus, query, resp.Code, resp.Body.String())
+		}
+		return resp
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		resp := export("format=csv", http.StatusOK)
+		if contentType := resp.Header().Get("Content-Type"); contentType != "text/csv" {
+			t.Errorf("Expected content type text/csv, got %s\n", contentType)
+		}
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Expected valid CSV, got %v\n", err)
+		}
+		if len(records) != userCount+1 || strings.Join(records[0], ",") != "id,first_name,last_name,email,role,created_at" {
+			t.Fatalf("Expected a header and %d rows, got %d rows starting with %v\n", userCount, len(records), records[0])
+		}
+		if records[1][2] != "Smith, Jr" || records[1][3] != "user0@example.com" || records[1][4] != models.RoleUser {
+			t.Errorf("Expected the first user's row to be quoted and complete, got %v\n", records[1])
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		resp := export("", http.StatusOK)
+		scanner := bufio.NewScanner(resp.Body)
+		lines := 0
+		for scanner.Scan() {
+			var user models.User
+			if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+				t.Fatalf("Expected line %d to be a JSON user, got %v\n", lines+1, err)
+			}
+			if user.Password != nil {
+				t.Fatalf("Expected exports to leave out password hashes\n")
+			}
+			lines++
+		}
+		if lines != userCount {
+			t.Errorf("Expected %d lines, got %d\n", userCount, lines)
+		}
+	})
+
+	t.Run("json with filter", func(t *testing.T) {
+		resp := export("format=json&email=user1", http.StatusOK)
+		var users []models.User
+		if err := json.Unmarshal(resp.Body.Bytes(), &users); err != nil {
+			t.Fatalf("Expected a JSON array, got %v\n", err)
+		}
+		// user1, user10 to user19 and user100 to user199
+		if len(users) != 111 {
+			t.Errorf("Expected 111 users matching the filter, got %d\n", len(users))
+		}
+	})
+
+	t.Run("empty json", func(t *testing.T) {
+		resp := export("format=json&email=nobody", http.StatusOK)
+		if body := strings.TrimSpace(resp.Body.String()); body != "[]" {
+			t.Errorf("Expected an empty array, got %s\n", body)
+		}
+	})
+
+	export("format=xml", http.StatusBadRequest)
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+func TestInvitationForDeletedAccountSignsUpNewAccount(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	store := repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0))
+
+	ownerEmail, inviteeEmail := uniqueEmail(), uniqueEmail()
+	cleanupUsers(t, store, ownerEmail, inviteeEmail)
+
+	var users []models.User
+	for _, email := range []string{ownerEmail, inviteeEmail} {
+		id, err := store.SignUpNewUser(ctx, newTestUser(email))
+		if err != nil {
+			t.Fatalf("Failed to sign up %s: %v\n", email, err)
+		}
+		user, err := store.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to get user %d: %v\n", id, err)
+		}
+		users = append(users, user)
+	}
+	owner, deleted := users[0], users[1]
+
+	// The invitee was a member until their account was deleted
+	organization := createTestOrganization(t, dbPool, owner)
+	if _, err := queries.UpsertOrganization(ctx, dbPool, organization.Name, organization.Slug, map[int]string{deleted.ID: models.OrgRoleMember}); err != nil {
+		t.Fatalf("Failed to add member: %v\n", err)
+	}
+	if err := store.DeleteUserByID(ctx, deleted.ID, ownerEmail); err != nil {
+		t.Fatalf("Failed to delete user: %v\n", err)
+	}
+
+	membership, err := queries.GetMembership(ctx, dbPool, organization.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("Failed to get owner membership: %v\n", err)
+	}
+	invitation, err := queries.CreateInvitation(ctx, dbPool, queries.ScopeForMembership(membership), inviteeEmail, models.OrgRoleMember, owner, time.Hour)
+	if err != nil {
+		t.Fatalf("Expected the deleted account not to count as a member, got %v\n", err)
+	}
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(ctx, `DELETE FROM jobs WHERE kind = $1 AND (args->>'invitation_id')::bigint = $2`, models.InvitationEmailJob{}.Kind(), invitation.ID); err != nil {
+			t.Errorf("Failed to clean up invitation email: %v\n", err)
+		}
+	})
+
+	// The email is queued with the invitation, and only knows its id, as the token is issued when the email is sent
+	var args string
+	err = dbPool.QueryRow(ctx, `SELECT args::text FROM jobs WHERE kind = $1 AND (args->>'invitation_id')::bigint = $2`, models.InvitationEmailJob{}.Kind(), invitation.ID).Scan(&args)
+	if err != nil || args != fmt.Sprintf(`{"invitation_id": %d}`, invitation.ID) {
+		t.Errorf("Expected the invitation email to be queued with only the invitation id, got %s and %v\n", args, err)
+	}
+
+	token := "deleted-account-invitation-" + inviteeEmail
+	tokenHash := sha256.Sum256([]byte(token))
+	email, err := queries.IssueInvitationToken(ctx, dbPool, invitation.ID, hex.EncodeToString(tokenHash[:]))
+	if err != nil {
+		t.Fatalf("Failed to issue invitation token: %v\n", err)
+	}
+	if email.Email != inviteeEmail || email.OrganizationName != organization.Name || email.InvitedByEmail == nil || *email.InvitedByEmail != ownerEmail {
+		t.Errorf("Expected the invitation email to %s from %s for %s, got %+v\n", inviteeEmail, ownerEmail, organization.Name, email)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/invitations/"+token, nil)
+	req.SetPathValue("token", token)
+	resp := httptest.NewRecorder()
+	handlers.GetInvitation(store).ServeHTTP(resp, req)
+	var details models.InvitationDetails
+	if err = json.NewDecoder(resp.Body).Decode(&details); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("Failed to get invitation, status %d: %v\n", resp.Code, err)
+	}
+	if details.ExistingUser {
+		t.Errorf("Expected a deleted account not to be reported as an existing user\n")
+	}
+
+	form := url.Values{"first_name": {"New"}, "last_name": {"Account"}, "password": {"Password123!"}}
+	req = httptest.NewRequest(http.MethodPost, "/invitations/"+token+"/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("token", token)
+	resp = httptest.NewRecorder()
+	handlers.SignUpWithInvitation(store, store).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200 signing up with the invitation, got %d: %s\n", resp.Code, resp.Body.String())
+	}
+
+	user, err := store.GetUserByEmail(ctx, inviteeEmail)
+	if err != nil {
+		t.Fatalf("Failed to get new user: %v\n", err)
+	}
+	if user.ID == deleted.ID {
+		t.Errorf("Expected a new account rather than the deleted one\n")
+	}
+	if _, err = queries.GetMembership(ctx, dbPool, organization.ID, user.ID); err != nil {
+		t.Errorf("Expected the new account to join the organization, got %v\n", err)
+	}
+	if err = store.RestoreUserByID(ctx, deleted.ID, ownerEmail); !errors.Is(err, queries.ErrRestoreEmailTaken) {
+		t.Errorf("Expected the deleted account to no longer be restorable, got %v\n", err)
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/jobs"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type failingJob struct {
+	Name string `json:"name"`
+}
+
+func (failingJob) Kind() string { return "tests.failing" }
+
+// jobState returns the state, attempts and last error of the failing job with the name
+func jobState(t *testing.T, dbPool *pgxpool.Pool, name string) (string, int, *string) {
+	t.Helper()
+
+	var state string
+	var attempts int
+	var lastError *string
+	err := dbPool.QueryRow(context.Background(), `SELECT state, attempts, last_error FROM jobs WHERE kind = $1 AND args->>'name' = $2`, failingJob{}.Kind(), name).
+		Scan(&state, &attempts, &lastError)
+	if err != nil {
+		t.Fatalf("Failed to get job %s: %v\n", name, err)
+	}
+
+	return state, attempts, lastError
+}
+
+func TestJobsAreDeadLetteredAfterMaxAttempts(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	kinds := []string{failingJob{}.Kind()}
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM jobs WHERE kind = $1`, failingJob{}.Kind()); err != nil {
+			t.Errorf("Failed to clean up jobs: %v\n", err)
+		}
+	})
+
+	// A job that fails on its last attempt is marked dead by the worker
+	if _, err := queries.EnqueueJob(ctx, dbPool, failingJob{Name: "fails"}, queries.JobOptions{MaxAttempts: 2}); err != nil {
+		t.Fatalf("Failed to enqueue job: %v\n", err)
+	}
+	if _, err := dbPool.Exec(ctx, `UPDATE jobs SET attempts = 1 WHERE kind = $1`, failingJob{}.Kind()); err != nil {
+		t.Fatalf("Failed to use up the first attempt: %v\n", err)
+	}
+
+	workers := jobs.NewWorkers()
+	jobs.Register(workers, func(ctx context.Context, job jobs.Job[failingJob]) error {
+		return errors.New("sink unavailable")
+	})
+	workerCtx, stop := context.WithCancel(ctx)
+	done := workers.Start(workerCtx, dbPool, 1, 20*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for state, _, _ := jobState(t, dbPool, "fails"); state != "dead" && time.Now().Before(deadline); state, _, _ = jobState(t, dbPool, "fails") {
+		time.Sleep(20 * time.Millisecond)
+	}
+	stop()
+	<-done
+
+	if state, attempts, lastError := jobState(t, dbPool, "fails"); state != "dead" || attempts != 2 || lastError == nil || *lastError != "sink unavailable" {
+		t.Errorf("Expected the job to be dead after 2 attempts with its error, got %s after %d attempts with %v\n", state, attempts, lastError)
+	}
+
+	// Jobs left running by a worker that stopped are claimed again while they have attempts left, and dead otherwise
+	for _, name := range []string{"stale-retried", "stale-dead"} {
+		if _, err := queries.EnqueueJob(ctx, dbPool, failingJob{Name: name}, queries.JobOptions{MaxAttempts: 2}); err != nil {
+			t.Fatalf("Failed to enqueue job: %v\n", err)
+		}
+	}
+	_, err := dbPool.Exec(ctx, `
+		UPDATE jobs SET state = 'running', locked_at = CURRENT_TIMESTAMP - interval '1 hour',
+			attempts = CASE WHEN args->>'name' = 'stale-dead' THEN 2 ELSE 1 END
+		WHERE kind = $1 AND args->>'name' LIKE 'stale-%'`, failingJob{}.Kind())
+	if err != nil {
+		t.Fatalf("Failed to make the jobs stale: %v\n", err)
+	}
+
+	claimed, err := queries.ClaimJobs(ctx, dbPool, kinds, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to claim jobs: %v\n", err)
+	}
+	if len(claimed) != 1 || claimed[0].Attempts != 2 || string(claimed[0].Args) != `{"name": "stale-retried"}` {
+		t.Errorf("Expected only the stale job with attempts left to be claimed, got %+v\n", claimed)
+	}
+	if state, attempts, lastError := jobState(t, dbPool, "stale-dead"); state != "dead" || attempts != 2 || lastError == nil {
+		t.Errorf("Expected the stale job without attempts left to be dead after 2 attempts, got %s after %d attempts with %v\n", state, attempts, lastError)
+	}
+}
+
+func TestPurgeDeadJobs(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM jobs WHERE kind = $1`, failingJob{}.Kind()); err != nil {
+			t.Errorf("Failed to clean up jobs: %v\n", err)
+		}
+	})
+
+	for _, name := range []string{"dead-old", "dead-recent", "pending-old"} {
+		if _, err := queries.EnqueueJob(ctx, dbPool, failingJob{Name: name}, queries.JobOptions{}); err != nil {
+			t.Fatalf("Failed to enqueue job: %v\n", err)
+		}
+	}
+	_, err := dbPool.Exec(ctx, `
+		UPDATE jobs SET
+			state = CASE WHEN args->>'name' LIKE 'dead-%' THEN 'dead' ELSE state END,
+			run_at = CASE WHEN args->>'name' LIKE '%-old' THEN '1970-01-01' ELSE run_at END
+		WHERE kind = $1`, failingJob{}.Kind())
+	if err != nil {
+		t.Fatalf("Failed to set up jobs: %v\n", err)
+	}
+
+	// No other job can have been due for longer than this, so only the old dead job is purged
+	if _, err = queries.PurgeDeadJobs(ctx, dbPool, 50*365*24*time.Hour); err != nil {
+		t.Fatalf("Failed to purge dead jobs: %v\n", err)
+	}
+
+	rows, err := dbPool.Query(ctx, `SELECT args->>'name' FROM jobs WHERE kind = $1 ORDER BY args->>'name'`, failingJob{}.Kind())
+	if err != nil {
+		t.Fatalf("Failed to list jobs: %v\n", err)
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatalf("Failed to collect jobs: %v\n", err)
+	}
+	if len(names) != 2 || names[0] != "dead-recent" || names[1] != "pending-old" {
+		t.Errorf("Expected only the old dead job to be purged, got %v\n", names)
+	}
+}
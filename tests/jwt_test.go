@@ -2,57 +2,27 @@ package tests
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"html/template"
+	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
 	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// dbPool is the database connection pool used for the tests that require database interaction
-var dbPool *pgxpool.Pool
-var ctx, cancel = context.WithCancel(context.Background())
-
-func TestMain(m *testing.M) {
-	// Set up the database connection pool
-	dbConnStr := os.Getenv("DATABASE_URL")
-	config, err := pgxpool.ParseConfig(dbConnStr)
-	if err != nil {
-		log.Fatalf("Failed to parse database connection string.\n")
-	}
-
-	for i := 1; i <= 5; i++ {
-		dbPool, err = pgxpool.NewWithConfig(ctx, config)
-		if err == nil && dbPool != nil {
-			break
-		}
-		log.Printf("Failed to initialise database connection pool")
-		log.Printf(fmt.Sprintf("Retrying in %d seconds...", i*i))
-		time.Sleep(time.Duration(i*i) * time.Second)
-	}
-	if dbPool == nil {
-		log.Fatalf("Failed to initialise database connection pool after 5 attempts")
-	}
-	defer dbPool.Close()
-
-	// Run the tests
-	code := m.Run()
-
-	cancel()
-	// Exit after running the tests
-	os.Exit(code)
-}
-
 // Integration test for the user sign up flow
 func TestUserSignUpFlow(t *testing.T) {
 	// Prepare
-	ts := httptest.NewServer(handlers.SignUp(dbPool))
+	store := repository.NewMemory()
+	ts := httptest.NewServer(handlers.SignUp(store, store))
 	defer ts.Close()
 
 	email := "person@gmail.com"
@@ -77,13 +47,82 @@ func TestUserSignUpFlow(t *testing.T) {
 		t.Errorf("Expected status code 200, got %v\n", resp.StatusCode)
 	}
 
-	// Tear down
-	_, err = dbPool.Exec(ctx, "DELETE FROM users WHERE email = $1", email)
+	user, err := store.GetUserByEmail(context.Background(), email)
 	if err != nil {
-		t.Fatalf("Failed to delete user from database, %v\n", err)
+		t.Fatalf("Expected the signed up user to be stored, got %v\n", err)
+	}
+	if *user.FirstName != firstName || *user.LastName != lastName {
+		t.Errorf("Expected name %s %s, got %s %s\n", firstName, lastName, *user.FirstName, *user.LastName)
 	}
 }
 
+func TestUserLoginAndRefreshFlow(t *testing.T) {
+	store := repository.NewMemory()
+	mux := http.NewServeMux()
+	mux.Handle("POST /signup", handlers.SignUp(store, store))
+	mux.Handle("POST /login", handlers.Login(store, store))
+	mux.Handle("POST /refresh-token", handlers.RefreshToken(store, store))
+
+	form := url.Values{
+		"email":      {"Person@Example.com"},
+		"first_name": {"per"},
+		"last_name":  {"son"},
+		"password":   {"password"},
+	}
+	post(t, mux, "/signup", form, nil, http.StatusOK)
+
+	// The email is normalized on sign up, so signing up again with a different case is rejected
+	form.Set("email", "person@example.com")
+	post(t, mux, "/signup", form, nil, http.StatusConflict)
+
+	post(t, mux, "/login", url.Values{"email": {"person@example.com"}, "password": {"wrong password"}}, nil, http.StatusNotFound)
+	resp := post(t, mux, "/login", url.Values{"email": {"person@example.com"}, "password": {"password"}}, nil, http.StatusOK)
+
+	var refreshCookie *http.Cookie
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == "refresh_token" {
+			refreshCookie = cookie
+		}
+	}
+	if refreshCookie == nil {
+		t.Fatalf("Expected login to set a refresh token cookie")
+	}
+
+	post(t, mux, "/refresh-token", nil, refreshCookie, http.StatusOK)
+
+	// A refresh token is not an access token, as it stays valid after its session is revoked
+	protected := middleware.JWTAuthMiddleware(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshCookie.Value)
+	rec := httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 for a refresh token used as access token, got %d\n", rec.Code)
+	}
+}
+
+// post sends a form to the handler and checks the response status
+func post(t *testing.T, handler http.Handler, path string, form url.Values, cookie *http.Cookie, wantStatus int) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	if resp.Code != wantStatus {
+		t.Fatalf("Expected status code %d from %s, got %d: %s\n", wantStatus, path, resp.Code, resp.Body.String())
+	}
+
+	return resp
+}
+
 func TestCreateAccessToken(t *testing.T) {
 	email := "test@example.com"
 
@@ -116,3 +155,130 @@ func TestCreateAccessToken(t *testing.T) {
 		t.Errorf("Expected token to be valid, but it is expired")
 	}
 }
+
+func TestCreateOrganizationAccessToken(t *testing.T) {
+	email := "test@example.com"
+
+	tokenString, err := middleware.CreateOrganizationAccessToken(email, 42)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v\n", err)
+	}
+
+	claims, err := middleware.ParseToken(tokenString)
+	if err != nil {
+		t.Fatalf("Expected no error while parsing token, got %v\n", err)
+	}
+
+	if claims.OrganizationID != 42 {
+		t.Errorf("Expected organization id 42, got %v\n", claims.OrganizationID)
+	}
+}
+
+func TestJWTAuthMiddlewareLoadsActiveOrganization(t *testing.T) {
+	store := repository.NewMemory()
+	userID, err := store.SignUpNewUser(context.Background(), models.User{Email: "member@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to sign up user: %v\n", err)
+	}
+
+	handler := middleware.JWTAuthMiddleware(store, middleware.OrgRoleMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), models.OrgRoleAdmin))
+
+	get := func(organizationID int) int {
+		token, err := middleware.CreateOrganizationAccessToken("member@example.com", organizationID)
+		if err != nil {
+			t.Fatalf("Failed to create access token: %v\n", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/org", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// The organization in the token is only trusted while the user is a member of it with the required role
+	if status := get(7); status != http.StatusForbidden {
+		t.Errorf("Expected status code 403 without a membership, got %d\n", status)
+	}
+	store.AddMembership(models.Membership{OrganizationID: 7, UserID: userID, Role: models.OrgRoleMember})
+	if status := get(7); status != http.StatusForbidden {
+		t.Errorf("Expected status code 403 for a plain member, got %d\n", status)
+	}
+	store.AddMembership(models.Membership{OrganizationID: 7, UserID: userID, Role: models.OrgRoleAdmin})
+	if status := get(7); status != http.StatusNoContent {
+		t.Errorf("Expected status code 204 for an organization admin, got %d\n", status)
+	}
+}
+
+// Passwords are hashed exactly as they were validated, so characters that used to be HTML escaped don't push a
+// password that is within the limit past the most bcrypt will hash
+func TestPasswordsNearTheByteLimit(t *testing.T) {
+	store := repository.NewMemory()
+	mux := http.NewServeMux()
+	mux.Handle("POST /signup", handlers.SignUp(store, store))
+	mux.Handle("POST /login", handlers.Login(store, store))
+	mux.Handle("POST /me/password", middleware.JWTAuthMiddleware(store, handlers.ChangePassword(store)))
+
+	password := strings.Repeat("&", 10) + strings.Repeat("a", 62)
+	newPassword := "<'\"" + strings.Repeat("b", 69)
+	if len(password) != 72 || len(newPassword) != 72 {
+		t.Fatalf("Expected 72 byte passwords\n")
+	}
+
+	post(t, mux, "/signup", url.Values{"email": {"limit@example.com"}, "first_name": {"li"}, "last_name": {"mit"}, "password": {password}}, nil, http.StatusOK)
+	post(t, mux, "/login", url.Values{"email": {"limit@example.com"}, "password": {password}}, nil, http.StatusOK)
+	// The escaped form of the password is a different password
+	post(t, mux, "/login", url.Values{"email": {"limit@example.com"}, "password": {strings.Repeat("&amp;", 10) + strings.Repeat("a", 22)}}, nil, http.StatusNotFound)
+
+	token, err := middleware.CreateAccessToken("limit@example.com")
+	if err != nil {
+		t.Fatalf("Failed to create access token: %v\n", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/me/password", strings.NewReader(url.Values{"current_password": {password}, "new_password": {newPassword}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code 204 changing password, got %d: %s\n", resp.Code, resp.Body.String())
+	}
+
+	post(t, mux, "/login", url.Values{"email": {"limit@example.com"}, "password": {newPassword}}, nil, http.StatusOK)
+	post(t, mux, "/signup", url.Values{"email": {"over@example.com"}, "first_name": {"o"}, "last_name": {"ver"}, "password": {password + "a"}}, nil, http.StatusBadRequest)
+}
+
+// Accounts whose password was HTML escaped before it was hashed log in with the password as typed, and are rehashed
+// so that it matches directly from then on
+func TestLegacyEscapedPasswordIsRehashed(t *testing.T) {
+	testUserRepositories(t, func(t *testing.T, store repository.UserRepository) {
+		ctx := context.Background()
+		email := uniqueEmail()
+		cleanupUsers(t, store, email)
+
+		password := "tom&jerry<3"
+		legacyHash, err := bcrypt.GenerateFromPassword([]byte(template.HTMLEscapeString(password)), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf("Failed to hash password: %v\n", err)
+		}
+		user := newTestUser(email)
+		hash := string(legacyHash)
+		user.Password = &hash
+		if _, err = store.SignUpNewUser(ctx, user); err != nil {
+			t.Fatalf("Failed to sign up user: %v\n", err)
+		}
+
+		login := handlers.Login(store, store.(repository.SessionRepository))
+		post(t, login, "/login", url.Values{"email": {email}, "password": {"tom&jerry<4"}}, nil, http.StatusNotFound)
+		post(t, login, "/login", url.Values{"email": {email}, "password": {password}}, nil, http.StatusOK)
+
+		rehashed, err := store.GetUserByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v\n", err)
+		}
+		if err = bcrypt.CompareHashAndPassword([]byte(*rehashed.Password), []byte(password)); err != nil {
+			t.Errorf("Expected the stored hash to match the password as typed, got %v\n", err)
+		}
+		post(t, login, "/login", url.Values{"email": {email}, "password": {password}}, nil, http.StatusOK)
+	})
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestScopedTransactionCannotSeeOtherOrganizations(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	store := repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0))
+
+	emails := []string{uniqueEmail(), uniqueEmail()}
+	cleanupUsers(t, store, emails...)
+	var owners []models.User
+	var organizations []models.Organization
+	for _, email := range emails {
+		id, err := store.SignUpNewUser(ctx, newTestUser(email))
+		if err != nil {
+			t.Fatalf("Failed to sign up %s: %v\n", email, err)
+		}
+		owner, err := store.GetUserByID(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to get user %d: %v\n", id, err)
+		}
+		owners = append(owners, owner)
+		organizations = append(organizations, createTestOrganization(t, dbPool, owner))
+	}
+	scope := queries.OrgScope{OrganizationID: organizations[0].ID, UserID: owners[0].ID}
+	other := organizations[1]
+
+	// The queries deliberately skip OrgScope's own checks and don't filter on the organization, so only row level
+	// security keeps the other organization's rows out
+	err := scope.WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error {
+		checks := map[string]string{
+			"organizations": `SELECT count(*) FROM organizations WHERE id = $1`,
+			"memberships":   `SELECT count(*) FROM memberships WHERE organization_id = $1`,
+			"users":         `SELECT count(*) FROM users u JOIN memberships m ON m.user_id = u.id WHERE m.organization_id = $1`,
+		}
+		for table, query := range checks {
+			var count int
+			if err := tx.QueryRow(ctx, query, other.ID).Scan(&count); err != nil {
+				t.Fatalf("Failed to query %s: %v\n", table, err)
+			}
+			if count != 0 {
+				t.Errorf("Expected no %s of the other organization to be visible, got %d\n", table, count)
+			}
+		}
+
+		var visible int
+		if err := tx.QueryRow(ctx, `SELECT count(*) FROM users WHERE id = ANY($1)`, []int{owners[0].ID, owners[1].ID}).Scan(&visible); err != nil {
+			t.Fatalf("Failed to query users: %v\n", err)
+		}
+		if visible != 1 {
+			t.Errorf("Expected only the scope's own user to be visible, got %d users\n", visible)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to run scoped transaction: %v\n", err)
+	}
+
+	// Writes into the other organization are refused too
+	err = scope.WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO memberships (organization_id, user_id, role) VALUES ($1, $2, $3)`, other.ID, owners[0].ID, models.OrgRoleMember)
+		return err
+	})
+	if err == nil {
+		t.Errorf("Expected adding a member to another organization to fail\n")
+	}
+	if _, err = queries.GetMembership(ctx, dbPool, other.ID, owners[0].ID); err == nil {
+		t.Errorf("Expected no membership in the other organization\n")
+	}
+
+	if err = (queries.OrgScope{}).WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error { return nil }); err == nil {
+		t.Errorf("Expected a scoped transaction without an organization to be refused\n")
+	}
+}
+
+// memoryOrganizations serves memberships from the in-memory store, for handlers that only look them up
+type memoryOrganizations struct {
+	repository.OrganizationRepository
+	store *repository.Memory
+}
+
+func (o memoryOrganizations) GetMembership(ctx context.Context, organizationID, userID int) (models.Membership, error) {
+	return o.store.GetMembership(ctx, organizationID, userID)
+}
+
+func TestSwitchOrganization(t *testing.T) {
+	store := repository.NewMemory()
+	email := "member@example.com"
+	form := url.Values{"email": {email}, "first_name": {"mem"}, "last_name": {"ber"}, "password": {"password"}}
+	post(t, handlers.SignUp(store, store), "/signup", form, nil, http.StatusOK)
+	resp := post(t, handlers.Login(store, store), "/login", url.Values{"email": {email}, "password": {"password"}}, nil, http.StatusOK)
+
+	var refreshCookie *http.Cookie
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == "refresh_token" {
+			refreshCookie = cookie
+		}
+	}
+	if refreshCookie == nil {
+		t.Fatalf("Expected login to set a refresh token cookie")
+	}
+	claims, err := middleware.ParseToken(refreshCookie.Value)
+	if err != nil {
+		t.Fatalf("Failed to parse refresh token: %v\n", err)
+	}
+	user, err := store.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v\n", err)
+	}
+	store.AddMembership(models.Membership{OrganizationID: 7, UserID: user.ID, Role: models.OrgRoleMember})
+
+	accessToken, err := middleware.CreateAccessToken(email)
+	if err != nil {
+		t.Fatalf("Failed to create access token: %v\n", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /orgs/{id}/switch", middleware.JWTAuthMiddleware(store, handlers.SwitchOrganization(memoryOrganizations{store: store}, store)))
+	switchTo := func(organizationID string) int {
+		req := httptest.NewRequest(http.MethodPost, "/orgs/"+organizationID+"/switch", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		req.AddCookie(refreshCookie)
+		resp := httptest.NewRecorder()
+		mux.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if status := switchTo("8"); status != http.StatusNotFound {
+		t.Errorf("Expected status code 404 for an organization the user isn't a member of, got %d\n", status)
+	}
+	if status := switchTo("7"); status != http.StatusOK {
+		t.Errorf("Expected status code 200 for the user's organization, got %d\n", status)
+	}
+
+	// Refreshed access tokens stay in the organization the session was switched to
+	organizationID, err := store.TouchSession(context.Background(), claims.SessionID, user.ID)
+	if err != nil || organizationID != 7 {
+		t.Errorf("Expected the session to be in organization 7, got %d and %v\n", organizationID, err)
+	}
+}
@@ -0,0 +1,251 @@
+package tests
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/outbox"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestOutboxBusSubjects(t *testing.T) {
+	bus := outbox.NewBus()
+	received := map[string]int{}
+	for _, subject := range []string{"users.created", "users.*", ">", "users.>", "organizations.*", "users"} {
+		bus.Subscribe(subject, func(ctx context.Context, event models.OutboxEvent) error {
+			received[subject]++
+			return nil
+		})
+	}
+
+	if err := bus.Publish(context.Background(), models.OutboxEvent{ID: 1, EventType: "users.created"}); err != nil {
+		t.Fatalf("Expected no error publishing to the bus, got %v\n", err)
+	}
+
+	expected := map[string]int{"users.created": 1, "users.*": 1, ">": 1, "users.>": 1}
+	for subject, count := range expected {
+		if received[subject] != count {
+			t.Errorf("Expected subscription %q to receive %d events, got %d\n", subject, count, received[subject])
+		}
+	}
+	if len(received) != len(expected) {
+		t.Errorf("Expected only %d subscriptions to receive the event, got %v\n", len(expected), received)
+	}
+}
+
+func TestOutboxWebhookSink(t *testing.T) {
+	secret := "webhook-secret"
+	status := http.StatusOK
+	var signature, eventID, body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body, signature, eventID = string(data), r.Header.Get("X-Signature"), r.Header.Get("X-Event-ID")
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	sink := outbox.NewWebhookSink(ts.URL, secret)
+	event := models.OutboxEvent{ID: 42, AggregateType: "user", AggregateID: "7", EventType: "users.deleted", Payload: map[string]any{"id": 7}}
+
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Expected no error publishing to the webhook, got %v\n", err)
+	}
+	if eventID != "42" {
+		t.Errorf("Expected X-Event-ID 42, got %q\n", eventID)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != expected {
+		t.Errorf("Expected signature %q, got %q\n", expected, signature)
+	}
+
+	// Failed deliveries are reported so the relay retries them
+	status = http.StatusServiceUnavailable
+	if err := sink.Publish(context.Background(), event); err == nil {
+		t.Errorf("Expected an error when the webhook responds with status %d\n", status)
+	}
+}
+
+// userOutboxEvents returns the outbox events about the user in the order they were written, and removes them once the
+// test is done
+func userOutboxEvents(t *testing.T, dbPool *pgxpool.Pool, userID int) []models.OutboxEvent {
+	t.Helper()
+
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM outbox_events WHERE aggregate_type = 'user' AND aggregate_id = $1`, strconv.Itoa(userID)); err != nil {
+			t.Errorf("Failed to clean up outbox events of user %d: %v\n", userID, err)
+		}
+	})
+
+	rows, err := dbPool.Query(context.Background(), `
+		SELECT id, aggregate_type, aggregate_id, event_type, payload, created_at, attempts FROM outbox_events
+		WHERE aggregate_type = 'user' AND aggregate_id = $1 ORDER BY id`, strconv.Itoa(userID))
+	if err != nil {
+		t.Fatalf("Failed to query outbox events: %v\n", err)
+	}
+	events, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.OutboxEvent])
+	if err != nil {
+		t.Fatalf("Failed to collect outbox events: %v\n", err)
+	}
+
+	return events
+}
+
+func TestUserOutboxEvents(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	email, newEmail := uniqueEmail(), uniqueEmail()
+	cleanupUsers(t, repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0)), email, newEmail)
+
+	userID, err := queries.SignUpNewUser(ctx, dbPool, newTestUser(email))
+	if err != nil {
+		t.Fatalf("Failed to sign up user: %v\n", err)
+	}
+
+	if err = queries.CreateEmailChangeRequest(ctx, dbPool, userID, newEmail, time.Hour); err != nil {
+		t.Fatalf("Failed to request email change: %v\n", err)
+	}
+	var requestID int64
+	if err = dbPool.QueryRow(ctx, `SELECT id FROM email_change_requests WHERE user_id = $1`, userID).Scan(&requestID); err != nil {
+		t.Fatalf("Failed to get email change request: %v\n", err)
+	}
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(ctx, `DELETE FROM jobs WHERE kind = $1 AND (args->>'email_change_request_id')::bigint = $2`, models.EmailChangeConfirmationJob{}.Kind(), requestID); err != nil {
+			t.Errorf("Failed to clean up email change confirmation: %v\n", err)
+		}
+	})
+
+	// The confirmation is queued with the request, and its token is only issued when the email is sent
+	var queued int
+	err = dbPool.QueryRow(ctx, `SELECT count(*) FROM jobs WHERE kind = $1 AND (args->>'email_change_request_id')::bigint = $2`, models.EmailChangeConfirmationJob{}.Kind(), requestID).Scan(&queued)
+	if err != nil || queued != 1 {
+		t.Errorf("Expected the confirmation email to be queued with the request, got %d and %v\n", queued, err)
+	}
+	tokenHash := sha256.Sum256([]byte(newEmail))
+	if _, _, err = queries.ConfirmEmailChange(ctx, dbPool, hex.EncodeToString(tokenHash[:])); !errors.Is(err, queries.ErrEmailChangeNotFound) {
+		t.Errorf("Expected a request without a token not to be confirmable, got %v\n", err)
+	}
+	if _, err = queries.IssueEmailChangeToken(ctx, dbPool, requestID, hex.EncodeToString(tokenHash[:])); err != nil {
+		t.Fatalf("Failed to issue email change token: %v\n", err)
+	}
+	if _, _, err = queries.ConfirmEmailChange(ctx, dbPool, hex.EncodeToString(tokenHash[:])); err != nil {
+		t.Fatalf("Failed to confirm email change: %v\n", err)
+	}
+
+	// The user is purged once it has been deleted for longer than the retention period, which no other user has been
+	if err = queries.DeleteUserByID(ctx, dbPool, userID, newEmail); err != nil {
+		t.Fatalf("Failed to delete user: %v\n", err)
+	}
+	if _, err = dbPool.Exec(ctx, `UPDATE users SET deleted_at = '1970-01-01' WHERE id = $1`, userID); err != nil {
+		t.Fatalf("Failed to backdate deletion: %v\n", err)
+	}
+	if purged, _, err := queries.PurgeDeletedUsers(ctx, dbPool, 50*365*24*time.Hour); err != nil || purged != 1 {
+		t.Fatalf("Expected the user to be purged, got %d purged and %v\n", purged, err)
+	}
+
+	events := userOutboxEvents(t, dbPool, userID)
+	expected := []string{queries.UserCreatedEvent, queries.UserUpdatedEvent, queries.UserDeletedEvent, queries.UserDeletedEvent}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected events %v, got %v\n", expected, events)
+	}
+	for i, event := range events {
+		if event.EventType != expected[i] {
+			t.Errorf("Expected event %d to be %s, got %s\n", i, expected[i], event.EventType)
+		}
+	}
+	if changes, _ := events[1].Payload["changes"].(map[string]any); changes["email"] != newEmail {
+		t.Errorf("Expected the email change in the users.updated payload, got %v\n", events[1].Payload)
+	}
+	if events[2].Payload["permanent"] != nil || events[3].Payload["permanent"] != true {
+		t.Errorf("Expected only the purge to be a permanent deletion, got %v and %v\n", events[2].Payload, events[3].Payload)
+	}
+}
+
+func TestRelayOutboxEventsBlocksFailedAggregates(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+
+	// The events are about aggregates no other test uses, and other pending events are delivered without looking at them
+	aggregateType := fmt.Sprintf("relay-test-%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		if _, err := dbPool.Exec(context.Background(), `DELETE FROM outbox_events WHERE aggregate_type = $1`, aggregateType); err != nil {
+			t.Errorf("Failed to clean up outbox events: %v\n", err)
+		}
+	})
+	err := queries.WithTx(ctx, dbPool, queries.TxOptions{}, func(tx pgx.Tx) error {
+		for _, event := range []struct{ aggregate, eventType string }{{"a", "first"}, {"b", "first"}, {"a", "second"}, {"b", "second"}} {
+			err := queries.InsertOutboxEvent(ctx, tx, models.OutboxEvent{AggregateType: aggregateType, AggregateID: event.aggregate, EventType: event.eventType})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to insert outbox events: %v\n", err)
+	}
+
+	var delivered []string
+	failFirstA := true
+	relay := func() {
+		t.Helper()
+		deliver := func(ctx context.Context, event models.OutboxEvent) error {
+			if event.AggregateType != aggregateType {
+				return nil
+			}
+			if event.AggregateID == "a" && failFirstA {
+				return errors.New("sink unavailable")
+			}
+			delivered = append(delivered, event.AggregateID+":"+event.EventType)
+			return nil
+		}
+		retryDelay := func(attempts int) time.Duration { return time.Hour }
+		if _, _, err := queries.RelayOutboxEvents(ctx, dbPool, 100000, deliver, retryDelay); err != nil {
+			t.Fatalf("Failed to relay outbox events: %v\n", err)
+		}
+	}
+
+	// The failed event holds back the later event about a, but not the events about b
+	relay()
+	if fmt.Sprint(delivered) != "[b:first b:second]" {
+		t.Errorf("Expected only the events about b to be delivered, got %v\n", delivered)
+	}
+
+	// a stays blocked until its failed event is due again
+	failFirstA = false
+	delivered = nil
+	relay()
+	if len(delivered) != 0 {
+		t.Errorf("Expected nothing to be delivered before the retry is due, got %v\n", delivered)
+	}
+
+	if _, err = dbPool.Exec(ctx, `UPDATE outbox_events SET next_attempt_at = CURRENT_TIMESTAMP WHERE aggregate_type = $1`, aggregateType); err != nil {
+		t.Fatalf("Failed to make the retry due: %v\n", err)
+	}
+	relay()
+	if fmt.Sprint(delivered) != "[a:first a:second]" {
+		t.Errorf("Expected the events about a to be delivered in order once the retry is due, got %v\n", delivered)
+	}
+
+	var attempts int
+	var lastError *string
+	err = dbPool.QueryRow(ctx, `SELECT attempts, last_error FROM outbox_events WHERE aggregate_type = $1 AND aggregate_id = 'a' AND event_type = 'first'`, aggregateType).Scan(&attempts, &lastError)
+	if err != nil || attempts != 2 || lastError != nil {
+		t.Errorf("Expected the retried event to be published on its second attempt, got %d attempts, error %v and %v\n", attempts, lastError, err)
+	}
+}
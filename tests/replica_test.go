@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestReadRouterFallsBackToPrimary(t *testing.T) {
+	ctx := context.Background()
+
+	// Neither pool connects until it is used, and nothing listens on port 1, so the replica is unreachable
+	primary, err := pgxpool.New(ctx, "postgres://app@127.0.0.1:1/primary?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("Failed to create primary pool: %v\n", err)
+	}
+	defer primary.Close()
+	replica, err := pgxpool.New(ctx, "postgres://app@127.0.0.1:1/replica?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("Failed to create replica pool: %v\n", err)
+	}
+	defer replica.Close()
+
+	if router := database.NewReadRouter(primary, nil, time.Second); router.Reader() != primary {
+		t.Errorf("Expected reads to use the primary without a replica\n")
+	}
+
+	router := database.NewReadRouter(primary, replica, time.Second)
+	if router.Reader() != primary {
+		t.Errorf("Expected reads to use the primary before the replica is checked\n")
+	}
+
+	router.CheckReplica(ctx)
+	if router.Reader() != primary {
+		t.Errorf("Expected reads to use the primary while the replica is unreachable\n")
+	}
+	if router.Primary() != primary {
+		t.Errorf("Expected Primary to return the primary pool\n")
+	}
+}
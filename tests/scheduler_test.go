@@ -0,0 +1,47 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/scheduler"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2026, time.January, 30, 10, 7, 30, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, time.January, 30, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.January, 30, 11, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2026, time.January, 31, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2026, time.February, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2026, time.January, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// When both day fields are restricted either one matching is enough, like cron
+		{"0 0 1 * 7", time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		// but a day field starting with * doesn't count as restricted, so both have to match
+		{"0 0 */2 * 1", time.Date(2026, time.February, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		schedule, err := scheduler.ParseSchedule(tt.expr)
+		if err != nil {
+			t.Fatalf("Expected %q to parse, got %v\n", tt.expr, err)
+		}
+		if next := schedule.Next(from); !next.Equal(tt.expected) {
+			t.Errorf("Expected next run of %q to be %v, got %v\n", tt.expr, tt.expected, next)
+		}
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "*/0 * * * *", "5-1 * * * *", "@often"} {
+		if _, err := scheduler.ParseSchedule(expr); err == nil {
+			t.Errorf("Expected %q to be rejected\n", expr)
+		}
+	}
+}
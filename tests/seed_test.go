@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/seed"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
+)
+
+func TestSeedFixturesAreIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	target := seed.Target{Users: store}
+
+	fixtures, err := seed.Load(os.DirFS("../fixtures"), "test")
+	if err != nil {
+		t.Fatalf("Failed to load test fixtures: %v\n", err)
+	}
+
+	for range 2 {
+		if err := seed.Apply(ctx, target, fixtures); err != nil {
+			t.Fatalf("Failed to apply test fixtures: %v\n", err)
+		}
+	}
+
+	users, err := store.GetAllUsers(ctx, queries.UserFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list users: %v\n", err)
+	}
+	if len(users) != len(fixtures.Users) {
+		t.Fatalf("Expected %d users after seeding twice, got %d\n", len(fixtures.Users), len(users))
+	}
+
+	admin, err := store.GetUserByEmail(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatalf("Expected the admin fixture to be stored, got %v\n", err)
+	}
+	if admin.Role != models.RoleAdmin || admin.Version != 1 {
+		t.Errorf("Expected an unchanged admin at version 1, got role %s at version %d\n", admin.Role, admin.Version)
+	}
+
+	// Seeding again brings a changed user back in line with its fixture
+	role := models.RoleUser
+	if _, err := store.UpdateUser(ctx, admin.ID, admin.Version, queries.UserUpdate{Role: &role}, "someone@example.com"); err != nil {
+		t.Fatalf("Failed to update admin: %v\n", err)
+	}
+	if err := seed.Apply(ctx, target, fixtures); err != nil {
+		t.Fatalf("Failed to reapply test fixtures: %v\n", err)
+	}
+	if admin, _ = store.GetUserByEmail(ctx, "admin@example.com"); admin.Role != models.RoleAdmin {
+		t.Errorf("Expected seeding to restore the admin role, got %s\n", admin.Role)
+	}
+
+	// Seeded users can log in with their fixture passwords
+	ts := httptest.NewServer(handlers.Login(store, store))
+	defer ts.Close()
+
+	resp, err := ts.Client().PostForm(ts.URL, url.Values{"email": {"user@example.com"}, "password": {"Password123!"}})
+	if err != nil {
+		t.Fatalf("Expected no error when logging in, got %v\n", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200 logging in as a seeded user, got %v\n", resp.StatusCode)
+	}
+}
+
+func TestSeedCreatesUsersLikeSignUp(t *testing.T) {
+	testUserRepositories(t, func(t *testing.T, store repository.UserRepository) {
+		ctx := context.Background()
+		email := uniqueEmail()
+		cleanupUsers(t, store, email)
+
+		// The password ends in characters HTML escaping would change, right up to the byte limit
+		password := strings.Repeat("a", validation.MaxPasswordBytes-4) + "1&<>"
+		firstName, lastName := "Seeded", "Admin"
+		fixtures := seed.Fixtures{Users: []seed.User{{
+			Email:      email,
+			Password:   password,
+			FirstName:  &firstName,
+			LastName:   &lastName,
+			Role:       models.RoleAdmin,
+			Attributes: map[string]any{"department": "sales", "employee_number": 42.0},
+		}}}
+		target := seed.Target{Users: store, Registry: newTestRegistry(t)}
+
+		for range 2 {
+			if err := seed.Apply(ctx, target, fixtures); err != nil {
+				t.Fatalf("Failed to apply fixtures: %v\n", err)
+			}
+		}
+
+		// The first run creates the user with its role and attributes, so the second has nothing to change
+		user, err := store.GetUserByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("Expected the fixture to be stored, got %v\n", err)
+		}
+		if user.Role != models.RoleAdmin || user.Version != 1 {
+			t.Errorf("Expected an admin at version 1, got role %s at version %d\n", user.Role, user.Version)
+		}
+		if user.Attributes["department"] != "sales" || fmt.Sprint(user.Attributes["employee_number"]) != "42" {
+			t.Errorf("Expected the fixture attributes to be stored, got %v\n", user.Attributes)
+		}
+
+		ts := httptest.NewServer(handlers.Login(store, store.(repository.SessionRepository)))
+		defer ts.Close()
+		resp, err := ts.Client().PostForm(ts.URL, url.Values{"email": {email}, "password": {password}})
+		if err != nil {
+			t.Fatalf("Expected no error when logging in, got %v\n", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status code 200 logging in with the fixture password, got %v\n", resp.StatusCode)
+		}
+	})
+}
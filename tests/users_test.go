@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/database"
+	"github.com/anishsharma21/go-backend-starter-template/internal/handlers"
+	"github.com/anishsharma21/go-backend-starter-template/internal/middleware"
+	"github.com/anishsharma21/go-backend-starter-template/internal/queries"
+	"github.com/anishsharma21/go-backend-starter-template/internal/repository"
+	"github.com/anishsharma21/go-backend-starter-template/internal/types/models"
+)
+
+// testUserRepositories runs the test against the in-memory store and, when there is a test database, against Postgres,
+// so both are held to the same behaviour
+func testUserRepositories(t *testing.T, test func(t *testing.T, store repository.UserRepository)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, repository.NewMemory())
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dbPool := testDBPool(t)
+		test(t, repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0)))
+	})
+}
+
+// cleanupUsers permanently removes the users with the emails once the test is done, when it ran against Postgres
+func cleanupUsers(t *testing.T, store repository.UserRepository, emails ...string) {
+	if _, ok := store.(*repository.Postgres); !ok {
+		return
+	}
+	dbPool := testDBPool(t)
+	t.Cleanup(func() {
+		for _, email := range emails {
+			if _, err := dbPool.Exec(context.Background(), `DELETE FROM users WHERE lower(email) = lower($1)`, email); err != nil {
+				t.Errorf("Failed to clean up user %s: %v\n", email, err)
+			}
+		}
+	})
+}
+
+// newTestUser returns a user to sign up, its password hash isn't of any password
+func newTestUser(email string) models.User {
+	firstName, lastName, password := "Test", "User", "not-a-hash"
+	return models.User{Email: email, FirstName: &firstName, LastName: &lastName, Password: &password}
+}
+
+func TestSoftDeletedUserEmailCanBeReused(t *testing.T) {
+	testUserRepositories(t, func(t *testing.T, store repository.UserRepository) {
+		ctx := context.Background()
+		email := uniqueEmail()
+		cleanupUsers(t, store, email)
+
+		firstID, err := store.SignUpNewUser(ctx, newTestUser(email))
+		if err != nil {
+			t.Fatalf("Failed to sign up user: %v\n", err)
+		}
+		if _, err = store.SignUpNewUser(ctx, newTestUser(email)); !errors.Is(err, queries.ErrEmailTaken) {
+			t.Fatalf("Expected signing up with an active user's email to fail with %q, got %v\n", queries.ErrEmailTaken, err)
+		}
+
+		if err = store.DeleteUserByID(ctx, firstID, "admin@example.com"); err != nil {
+			t.Fatalf("Failed to soft delete user: %v\n", err)
+		}
+		if _, err = store.SignUpNewUser(ctx, newTestUser(email)); err != nil {
+			t.Fatalf("Expected the email of a soft deleted user to be free, got %v\n", err)
+		}
+
+		err = store.RestoreUserByID(ctx, firstID, "admin@example.com")
+		if !errors.Is(err, queries.ErrRestoreEmailTaken) || !errors.Is(err, queries.ErrConflict) {
+			t.Errorf("Expected restoring a user whose email was taken to conflict, got %v\n", err)
+		}
+	})
+}
+
+func TestSignUpKeepsRoleAndAttributes(t *testing.T) {
+	testUserRepositories(t, func(t *testing.T, store repository.UserRepository) {
+		ctx := context.Background()
+		admin, plain := uniqueEmail(), uniqueEmail()
+		cleanupUsers(t, store, admin, plain)
+
+		user := newTestUser(admin)
+		user.Role = models.RoleAdmin
+		user.Attributes = map[string]any{"plan": "pro"}
+		if _, err := store.SignUpNewUser(ctx, user); err != nil {
+			t.Fatalf("Failed to sign up admin: %v\n", err)
+		}
+		if _, err := store.SignUpNewUser(ctx, newTestUser(plain)); err != nil {
+			t.Fatalf("Failed to sign up user: %v\n", err)
+		}
+
+		stored, err := store.GetUserByEmail(ctx, admin)
+		if err != nil {
+			t.Fatalf("Failed to get admin: %v\n", err)
+		}
+		if stored.Role != models.RoleAdmin || stored.Attributes["plan"] != "pro" {
+			t.Errorf("Expected the admin role and attributes to be stored, got role %s and attributes %v\n", stored.Role, stored.Attributes)
+		}
+
+		// Without a role or attributes the user gets the defaults
+		if stored, err = store.GetUserByEmail(ctx, plain); err != nil {
+			t.Fatalf("Failed to get user: %v\n", err)
+		}
+		if stored.Role != models.RoleUser || stored.Attributes == nil || len(stored.Attributes) != 0 {
+			t.Errorf("Expected a plain user without attributes, got role %s and attributes %v\n", stored.Role, stored.Attributes)
+		}
+	})
+}
+
+func TestBulkDeleteUsers(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	admin := newTestUser("admin@example.com")
+	admin.Role = models.RoleAdmin
+	var ids []int
+	for _, user := range []models.User{admin, newTestUser("a@example.com"), newTestUser("b@example.com")} {
+		id, err := store.SignUpNewUser(ctx, user)
+		if err != nil {
+			t.Fatalf("Failed to sign up %s: %v\n", user.Email, err)
+		}
+		ids = append(ids, id)
+	}
+
+	handler := middleware.JWTAuthMiddleware(store, middleware.AdminOnlyMiddleware(handlers.DeleteUsers(store, newTestRegistry(t))))
+	send := func(email, body string, wantStatus int) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodDelete, "/users", strings.NewReader(body))
+		if email != "" {
+			token, err := middleware.CreateAccessToken(email)
+			if err != nil {
+				t.Fatalf("Failed to create access token: %v\n", err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != wantStatus {
+			t.Fatalf("Expected status code %d for %s, got %d: %s\n", wantStatus, body, resp.Code, resp.Body.String())
+		}
+		return resp.Body.String()
+	}
+	others := fmt.Sprintf(`[%d, %d]`, ids[1], ids[2])
+
+	send("", `{"ids": `+others+`, "confirm": true}`, http.StatusUnauthorized)
+	send("a@example.com", `{"ids": `+others+`, "confirm": true}`, http.StatusForbidden)
+	send("admin@example.com", `{"confirm": true}`, http.StatusBadRequest)
+	send("admin@example.com", `{"ids": `+others+`}`, http.StatusBadRequest)
+	send("admin@example.com", `{"filter": {"attributes": {"department": "marketing"}}, "dry_run": true}`, http.StatusBadRequest)
+
+	if body := send("admin@example.com", `{"ids": `+others+`, "dry_run": true}`, http.StatusOK); !strings.Contains(body, `"count":2`) {
+		t.Errorf("Expected a dry run to report 2 users, got %s\n", body)
+	}
+	if users, _ := store.GetAllUsers(ctx, queries.UserFilter{}); len(users) != 3 {
+		t.Errorf("Expected a dry run to delete nothing, got %d users left\n", len(users))
+	}
+
+	send("admin@example.com", `{"filter": {"email": "a@"}, "confirm": true}`, http.StatusOK)
+	if _, err := store.GetUserByEmail(ctx, "a@example.com"); !errors.Is(err, queries.ErrUserNotFound) {
+		t.Errorf("Expected the filtered user to be deleted, got %v\n", err)
+	}
+	if _, err := store.GetUserByEmail(ctx, "b@example.com"); err != nil {
+		t.Errorf("Expected the other user to be left alone, got %v\n", err)
+	}
+}
+
+func TestBulkDeleteDryRunDoesNotLockUsers(t *testing.T) {
+	dbPool := testDBPool(t)
+	ctx := context.Background()
+	store := repository.NewPostgres(database.NewReadRouter(dbPool, nil, 0))
+	email := uniqueEmail()
+	cleanupUsers(t, store, email)
+
+	id, err := store.SignUpNewUser(ctx, newTestUser(email))
+	if err != nil {
+		t.Fatalf("Failed to sign up user: %v\n", err)
+	}
+
+	// Another transaction holds a lock on the user while the preview runs
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v\n", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, id); err != nil {
+		t.Fatalf("Failed to lock user: %v\n", err)
+	}
+
+	previewCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	users, err := store.BulkDeleteUsers(previewCtx, queries.UserFilter{IDs: []int{id}}, "admin@example.com", true)
+	if err != nil {
+		t.Fatalf("Expected a dry run not to wait for the lock, got %v\n", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("Expected the dry run to match 1 user, got %d\n", len(users))
+	}
+}
+
+func TestGetUserByEmailNotFound(t *testing.T) {
+	testUserRepositories(t, func(t *testing.T, store repository.UserRepository) {
+		if _, err := store.GetUserByEmail(context.Background(), uniqueEmail()); !errors.Is(err, queries.ErrUserNotFound) {
+			t.Errorf("Expected %q for an unknown email, got %v\n", queries.ErrUserNotFound, err)
+		}
+	})
+}
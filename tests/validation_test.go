@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/anishsharma21/go-backend-starter-template/internal/validation"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"bob@example.com", "bob@example.com"},
+		{"Bob@Example.COM", "bob@example.com"},
+		{"  bob@example.com ", "bob@example.com"},
+		{"tom&jerry@example.com", "tom&jerry@example.com"},
+		{"user@bücher.example", "user@xn--bcher-kva.example"},
+	}
+
+	for _, c := range cases {
+		email, err := validation.NormalizeEmail(c.input)
+		if err != nil {
+			t.Errorf("Expected no error normalizing %q, got %v\n", c.input, err)
+			continue
+		}
+		if email != c.expected {
+			t.Errorf("Expected %q to normalize to %q, got %q\n", c.input, c.expected, email)
+		}
+	}
+}
+
+func TestNormalizeEmailRejectsInvalidAddresses(t *testing.T) {
+	invalid := []string{
+		"",
+		"bob",
+		"bob@",
+		"@example.com",
+		"Bob <bob@example.com>",
+		"bob@localhost",
+		"bob@exa mple.com",
+	}
+
+	for _, input := range invalid {
+		if email, err := validation.NormalizeEmail(input); err == nil {
+			t.Errorf("Expected error normalizing %q, got %q\n", input, email)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	if err := validation.ValidatePassword("password"); err != nil {
+		t.Errorf("Expected no error for an 8 character password, got %v\n", err)
+	}
+
+	invalid := []string{"short", "        ", string(make([]byte, 73))}
+	for _, password := range invalid {
+		if err := validation.ValidatePassword(password); err == nil {
+			t.Errorf("Expected error for password %q\n", password)
+		}
+	}
+}